@@ -1,5 +1,5 @@
-; run with: `rlwrap go run *.go hello.lisp` or `rlwrap go run *.go hello.lisp World`
-; — or load this in a REPL session started with `rlwrap go run *.go` by calling `(loadFile "hello.lisp")`
+; run with: `go run *.go hello.lisp` or `go run *.go hello.lisp World`
+; — or load this in a REPL session started with `go run *.go` by calling `(loadFile "hello.lisp")`
 
 (def greet
     (fn (name)
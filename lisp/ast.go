@@ -1,4 +1,4 @@
-package main
+package lisp
 
 import (
 	"cmp"
@@ -36,7 +36,6 @@ type ExprNum int
 type ExprList []Expr
 type ExprVec []Expr
 type ExprHashMap map[string]Expr
-type ExprAtom struct{ Ref Expr }
 type ExprErr struct{ It any }
 type ExprFunc func([]Expr) (Expr, error)
 type ExprFn struct { // if it weren't for TCO, just the above `ExprFunc` would suffice.
@@ -45,7 +44,8 @@ type ExprFn struct { // if it weren't for TCO, just the above `ExprFunc` would s
 	env        *Env
 	isMacro    bool
 	isVariadic bool
-	nameMaybe  string
+	nameMaybe  string  // set by `def` before the `ExprFn` is shared, never afterwards
+	code       *fnCode // never `nil`
 }
 
 func (me *ExprFn) envWith(args []Expr) (*Env, error) {
@@ -53,21 +53,47 @@ func (me *ExprFn) envWith(args []Expr) (*Env, error) {
 	if me.isVariadic {
 		num_args_min, num_args_max = len(me.params)-1, -1
 	}
-	if err := checkArgsCount(num_args_min, num_args_max, strings.TrimSpace("function "+me.nameMaybe), args); err != nil {
-		return nil, err
+	if (len(args) < num_args_min) || ((num_args_max >= 0) && (len(args) > num_args_max)) { // checked before `checkArgsCount` to not build its `name` arg needlessly
+		return nil, checkArgsCount(num_args_min, num_args_max, strings.TrimSpace("function "+me.nameMaybe), args)
 	}
 	if me.isVariadic {
 		the_var_args := args[len(me.params)-1:]
 		args = append(append(make([]Expr, 0, len(me.params)-1+len(the_var_args)), args[:len(me.params)-1]...), (ExprList)(the_var_args))
 	}
-	return newEnv(me.env, me.params, args), nil
+	return newSlotsEnv(me.env, me.params, args), nil
 }
 
-// note, `(*ExprFn).Call` is itself an `ExprFunc`
+// note, `(*ExprFn).Call` is itself an `ExprFunc`. it calls `me` outside of any evaluation: from Go builtins, see `callIn` instead.
 func (me *ExprFn) Call(args []Expr) (Expr, error) {
+	return me.callIn(nil, args)
+}
+
+// callIn calls `me` for a Go builtin called in `caller` (unless `nil`), continuing that evaluation: with the
+// `context` of `caller`, and one call deeper. (else, it would start over at a depth of 0, and never be canceled.)
+func (me *ExprFn) callIn(caller *Env, args []Expr) (Expr, error) {
 	env, err := me.envWith(args)
 	if err != nil {
 		return nil, err
+	} else if caller != nil {
+		env.ctx = caller.context()
+		if me.env.interp.sandbox != nil {
+			if env.depth, err = me.env.interp.sandboxDepth(caller); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if profiler := &me.env.interp.Profiler; profiler.on.Load() {
+		env.prof = profiler.call(me, strings.Trim(me.nameMaybe, "`"), nil, nil)
+		defer profiler.ret(env.prof)
+	}
+	if debugger := &me.env.interp.Debugger; debugger.on.Load() {
+		frame, level := &StackFrame{Name: strings.Trim(me.nameMaybe, "`")}, debugLevel(int(debugger.nesting.Load())+1, false) // as for the forms of the body, evaluated below
+		if err = debugger.atCall(env, frame.Name, args, nil, level, frame); err != nil {
+			return nil, err
+		}
+	}
+	if code := me.compiled(); code != nil {
+		return code.eval(env)
 	}
 	return evalAndApply(env, me.body)
 }
@@ -100,11 +126,10 @@ func compare(args []Expr) (int, error) {
 	if err := checkArgsCount(2, 2, "comparer", args); err != nil {
 		return 0, err
 	}
+	if isNum(args[0]) && isNum(args[1]) {
+		return numCompare(args[0], args[1])
+	}
 	switch it := args[0].(type) {
-	case ExprNum:
-		if other, ok := args[1].(ExprNum); ok {
-			return cmp.Compare(it, other), nil
-		}
 	case ExprStr:
 		if other, ok := args[1].(ExprStr); ok {
 			return cmp.Compare(it, other), nil
@@ -113,6 +138,21 @@ func compare(args []Expr) (int, error) {
 	return 0, fmt.Errorf("specified operands `%#v` and `%#v` are not comparable", args[0], args[1])
 }
 
+// compareChained checks that `isOrdered` holds for every two neighbouring `args`, as in `(< 1 x 10)`.
+func compareChained(name string, args []Expr, isOrdered func(int) bool) (Expr, error) {
+	if err := checkArgsCount(1, -1, name, args); err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(args); i++ {
+		if order, err := compare(args[i-1 : i+1]); err != nil {
+			return nil, err
+		} else if !isOrdered(order) {
+			return exprFalse, nil
+		}
+	}
+	return exprTrue, nil
+}
+
 func isListOrVec(seq Expr) bool {
 	ty := reflect.TypeOf(seq)
 	return (ty == reflect.TypeFor[ExprList]()) || (ty == reflect.TypeFor[ExprVec]())
@@ -128,6 +168,10 @@ func isListStartingWithIdent(maybeList Expr, ident ExprIdent, mustHaveLen int) (
 }
 
 func isEq(arg1 Expr, arg2 Expr) bool {
+	if isNum(arg1) && isNum(arg2) {
+		order, _ := numCompare(arg1, arg2)
+		return order == 0
+	}
 	ty1, ty2 := reflect.TypeOf(arg1), reflect.TypeOf(arg2)
 	if (ty1 != ty2) && ((!isListOrVec(arg1)) || !isListOrVec(arg2)) {
 		return false
@@ -136,7 +180,9 @@ func isEq(arg1 Expr, arg2 Expr) bool {
 	case ExprErr: // TODO: not fully correct this way:
 		return ((reflect.TypeOf(arg1.(ExprErr).It) == reflect.TypeOf(arg2.(ExprErr).It)) && (arg1.(ExprErr).Error() == arg2.(ExprErr).Error()))
 	case *ExprAtom:
-		return arg1.(*ExprAtom).Ref == arg2.(*ExprAtom).Ref
+		return arg1.(*ExprAtom).Get() == arg2.(*ExprAtom).Get()
+	case ExprGo:
+		return goValuesEq(arg1.(ExprGo), arg2.(ExprGo))
 	case ExprVec, ExprList:
 		sl1, _ := checkIsSeq(arg1)
 		sl2, _ := checkIsSeq(arg2)
@@ -0,0 +1,216 @@
+package lisp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ExprAtom is a reference to a changing value, safe for concurrent use. all changes are compare-and-swap based:
+// `atomSwap` re-runs its func on conflicting concurrent changes, so that no update gets lost. an atom can have a
+// validator func (that all new values must satisfy) and watch funcs (that get called after every change).
+type ExprAtom struct {
+	ref       atomic.Pointer[Expr]
+	validator Expr // `nil` or set only by `newAtom`: a callable, called (like the `watches`) in the `Env` of each change
+
+	mutex   sync.Mutex // for `watches`
+	watches []atomWatch
+}
+
+type atomWatch struct {
+	key string
+	fn  Expr // a callable
+}
+
+func newAtom(env *Env, ref Expr, validator Expr) (*ExprAtom, error) {
+	ret := ExprAtom{validator: validator}
+	if err := ret.validate(env, ref); err != nil {
+		return nil, err
+	}
+	ret.ref.Store(&ref)
+	return &ret, nil
+}
+
+func (me *ExprAtom) Get() Expr { return *me.ref.Load() }
+
+func (me *ExprAtom) validate(env *Env, ref Expr) error {
+	if me.validator == nil {
+		return nil
+	}
+	validator, err := callableIn(env, me.validator)
+	if err != nil {
+		return err
+	}
+	ok, err := validator([]Expr{ref})
+	if err != nil {
+		return err
+	} else if isNilOrFalse(ok) {
+		return fmt.Errorf("atom validator rejected `%s`", str(true, ref))
+	}
+	return nil
+}
+
+// compareAndSet changes the value to `ref` if it still is `old`, and if so returns `true` after calling all watches (in `env`).
+func (me *ExprAtom) compareAndSet(env *Env, old *Expr, ref Expr) (bool, error) {
+	if err := me.validate(env, ref); err != nil {
+		return false, err
+	} else if !me.ref.CompareAndSwap(old, &ref) {
+		return false, nil
+	}
+	me.mutex.Lock()
+	watches := slices.Clone(me.watches)
+	me.mutex.Unlock()
+	for _, watch := range watches {
+		fn, err := callableIn(env, watch.fn)
+		if err == nil {
+			_, err = fn([]Expr{exprStrOrKeyword(watch.key), me, *old, ref})
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func init() {
+	builtinsInEnv[funcAddr(stdAtomFrom)] = atomFrom
+	builtinsInEnv[funcAddr(stdAtomSet)] = atomSet
+	builtinsInEnv[funcAddr(stdAtomSwap)] = atomSwap
+	builtinsInEnv[funcAddr(stdAtomCompareAndSet)] = atomCompareAndSet
+}
+
+// stdAtomFrom makes a new atom: `(atomFrom 0)`, or with a validator: `(atomFrom 0 (fn (n) (>= n 0)))`.
+func stdAtomFrom(args []Expr) (Expr, error) { return atomFrom(nil, args) }
+func atomFrom(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 2, "`atomFrom`", args); err != nil {
+		return nil, err
+	}
+	var validator Expr
+	if len(args) > 1 {
+		if _, err := checkIsCallable(args[1]); err != nil {
+			return nil, err
+		}
+		validator = args[1]
+	}
+	return newAtom(env, args[0], validator)
+}
+
+func stdAtomGet(args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 1, "`atomGet`", args); err != nil {
+		return nil, err
+	}
+	atom, err := checkIs[*ExprAtom](args[0])
+	if err != nil {
+		return nil, err
+	}
+	return atom.Get(), nil
+}
+
+func stdAtomSet(args []Expr) (Expr, error) { return atomSet(nil, args) }
+func atomSet(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, 2, "`atomSet`", args); err != nil {
+		return nil, err
+	}
+	atom, err := checkIs[*ExprAtom](args[0])
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ok, err := atom.compareAndSet(env, atom.ref.Load(), args[1]); err != nil {
+			return nil, err
+		} else if ok {
+			return args[1], nil
+		}
+	}
+}
+
+// stdAtomSwap sets the atom to the result of calling `fn` with its current value and any further args:
+// `(atomSwap counter + 1)`. if the atom changed meanwhile, it retries with the new current value, so `fn`
+// might be called more than once and should have no side effects.
+func stdAtomSwap(args []Expr) (Expr, error) { return atomSwap(nil, args) }
+func atomSwap(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, -1, "`atomSwap`", args); err != nil {
+		return nil, err
+	}
+	atom, err := checkIs[*ExprAtom](args[0])
+	if err != nil {
+		return nil, err
+	}
+	if fn, _ := args[1].(*ExprFn); fakeFuncNamesForDebugging && (fn != nil) && (fn.nameMaybe == "") {
+		fn.nameMaybe = str(true, append(ExprList{ExprIdent("atomSwap")}, args...))
+	}
+	fn, err := callableIn(env, args[1])
+	if err != nil {
+		return nil, err
+	}
+	for {
+		old := atom.ref.Load()
+		ret, err := fn(append([]Expr{*old}, args[2:]...))
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := atom.compareAndSet(env, old, ret); err != nil {
+			return nil, err
+		} else if ok {
+			return ret, nil
+		}
+	}
+}
+
+// stdAtomCompareAndSet sets the atom to `new` only if its current value equals `old`: `(atomCompareAndSet atom old new)`.
+// returns whether it did.
+func stdAtomCompareAndSet(args []Expr) (Expr, error) { return atomCompareAndSet(nil, args) }
+func atomCompareAndSet(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(3, 3, "`atomCompareAndSet`", args); err != nil {
+		return nil, err
+	}
+	atom, err := checkIs[*ExprAtom](args[0])
+	if err != nil {
+		return nil, err
+	}
+	for {
+		cur := atom.ref.Load()
+		if !isEq(*cur, args[1]) {
+			return exprFalse, nil
+		}
+		if ok, err := atom.compareAndSet(env, cur, args[2]); err != nil {
+			return nil, err
+		} else if ok {
+			return exprTrue, nil
+		}
+	}
+}
+
+// stdAtomWatch registers `fn` to be called after every change of the atom, as `(fn key atom old new)`:
+// `(atomWatch atom :logger fn)`. another `fn` for the same key replaces it, and `:nil` for `fn` removes it.
+func stdAtomWatch(args []Expr) (Expr, error) {
+	if err := checkArgsCount(3, 3, "`atomWatch`", args); err != nil {
+		return nil, err
+	}
+	atom, err := checkIs[*ExprAtom](args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := checkIsStrOrKeyword(args[1])
+	if err != nil {
+		return nil, err
+	}
+	var fn Expr
+	if args[2] != exprNil {
+		if _, err = checkIsCallable(args[2]); err != nil {
+			return nil, err
+		}
+		fn = args[2]
+	}
+
+	atom.mutex.Lock()
+	defer atom.mutex.Unlock()
+	atom.watches = slices.DeleteFunc(atom.watches, func(it atomWatch) bool { return it.key == key })
+	if fn != nil {
+		atom.watches = append(atom.watches, atomWatch{key: key, fn: fn})
+		slices.SortFunc(atom.watches, func(a atomWatch, b atomWatch) int { return strings.Compare(a.key, b.key) })
+	}
+	return atom, nil
+}
@@ -0,0 +1,62 @@
+package lisp
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAtom(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		testEvalAll(t, NewInterpreter(Options{Bytecode: bytecode}), [][2]string{
+			{"(def a (atomFrom 1))", "(atomFrom 1)"},
+			{"(atomCompareAndSet a 1 2)", ":true"},
+			{"(atomCompareAndSet a 1 3)", ":false"},
+			{"(atomGet a)", "2"},
+			{"(atomCompareAndSet a (+ 1 1) (list 3))", ":true"},
+			{"(atomCompareAndSet a (list 3) 4)", ":true"}, // by equality, not identity
+			{"(atomSwap a + 10 20)", "34"},
+			{"(atomSet a 5)", "5"},
+
+			{"(def pos (atomFrom 1 (fn (n) (> n 0))))", "(atomFrom 1)"},
+			{"(atomSet pos 2)", "2"},
+			{"(atomSet pos -1)", "error: 1:1: atom validator rejected `-1`"},
+			{"(atomSwap pos - 10)", "error: 1:1: atom validator rejected `-8`"},
+			{"(atomCompareAndSet pos 2 0)", "error: 1:1: atom validator rejected `0`"},
+			{"(atomGet pos)", "2"},
+			{"(atomFrom 0 (fn (n) (> n 0)))", "error: 1:1: atom validator rejected `0`"},
+
+			{"(def log (atomFrom (list)))", "(atomFrom ())"},
+			{"(do (def logged (fn (key atom old new) (atomSwap log (fn (it) (cons (list key old new) it))))) (atomWatch a :first logged) (atomWatch a \"second\" logged) :nil)", ":nil"},
+			{"(atomSet a 6)", "6"},
+			{"(atomSwap a * 2)", "12"},
+			{"(atomCompareAndSet a 11 13)", ":false"}, // no change, so no watches called
+			{"(do (atomWatch a :first :nil) (atomSet a 7))", "7"},
+			{"(atomGet log)", "((\"second\" 12 7) (\"second\" 6 12) (:first 6 12) (\"second\" 5 6) (:first 5 6))"},
+		})
+	}
+}
+
+// TestAtomSwapConcurrently checks (especially with `go test -race`) that no `atomSwap` gets lost to concurrent ones.
+func TestAtomSwapConcurrently(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	testEvalAll(t, interp, [][2]string{{"(def counter (atomFrom 0))", "(atomFrom 0)"}})
+	const num_goroutines, num_swaps = 8, 250
+	var wait_group sync.WaitGroup
+	for range num_goroutines {
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+			for range num_swaps {
+				if _, err := interp.ReadAndEval("", "(atomSwap counter (fn (n) (+ n 1)))"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wait_group.Wait()
+	testEvalAll(t, interp, [][2]string{
+		{"(atomGet counter)", "2000"},
+		{"(do (pmap (fn (n) (atomSwap counter + n)) (list 1 2 3 4 5 6 7 8 9 10) 4) (atomGet counter))", "2055"},
+	})
+}
@@ -0,0 +1,389 @@
+package lisp
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+)
+
+// vmOp is the first byte of every bytecode instruction, followed by its fixed number (see `vmOpsNumOperands`) of
+// operands, each an unsigned 16-bit little-endian int. the VM in `vmRun` is a stack machine: all ops push their
+// result onto, and pop their operands from, the value stack of the current frame.
+type vmOp byte
+
+const (
+	vmOpConst      vmOp = iota // (idx): pushes `consts[idx]`
+	vmOpSlot                   // (depth, idx, site): pushes the value in slot `idx` of the `Env` `depth` levels up the chain
+	vmOpGlobal                 // (idx, site): pushes the value of `globals[idx]`
+	vmOpLookup                 // (site): pushes the value of the name of `sites[site]`, looked up in the `Env` chain
+	vmOpPop                    // (): drops the top of the stack
+	vmOpJump                   // (pc): continues at `pc`
+	vmOpJumpIfNot              // (pc): pops, and continues at `pc` if that was `:nil` or `:false`
+	vmOpMacroCheck             // (site, pc): if the top of the stack is a macro, replaces the call at `sites[site]` by its expansion, continuing at `pc`
+	vmOpCall                   // (site): pops the callee and args of the call at `sites[site]`, pushes its result
+	vmOpTailCall               // (site): like `vmOpCall`, but with the callee replacing the current frame
+	vmOpReturn                 // (): ends the current frame, its result being the top of its stack
+	vmOpClosure                // (idx): pushes a new `*ExprFn` like the `consts[idx]` one, capturing the current `Env`
+	vmOpDefCheck               // (site): checks the `def` or `set` at `sites[site]`, before its value gets evaluated
+	vmOpDef                    // (site): binds the top of the stack per the `def` or `set` at `sites[site]`
+	vmOpLet                    // (idx): makes a new child of the current `Env`, with the names in `consts[idx]` as its slots, the current one
+	vmOpLetBind                // (idx): pops into slot `idx` of the current `Env`
+	vmOpLetEnd                 // (): makes the `Parent` of the current `Env` the current one
+	vmOpVec                    // (n): pops `n` items into a new `ExprVec`
+	vmOpHashMap                // (idx): pops the values of the keys in `consts[idx]` into a new `ExprHashMap`
+	vmOpTry                    // (site, pc): sets up the `catch` of the `try` at `sites[site]`, whose handler is at `pc`
+	vmOpTryEnd                 // (): removes the innermost `catch` set up by the current frame
+	vmOpSpecial                // (site): calls the special form of `sites[site]` with the unevaluated args of its form
+	vmOpFail                   // (idx): fails with the error in the `consts[idx]` `ExprErr`
+)
+
+var vmOpsNumOperands = [...]int{
+	vmOpConst: 1, vmOpSlot: 3, vmOpGlobal: 2, vmOpLookup: 1, vmOpPop: 0, vmOpJump: 1, vmOpJumpIfNot: 1, vmOpMacroCheck: 2,
+	vmOpCall: 1, vmOpTailCall: 1, vmOpReturn: 0, vmOpClosure: 1, vmOpDefCheck: 1, vmOpDef: 1, vmOpLet: 1, vmOpLetBind: 1,
+	vmOpLetEnd: 0, vmOpVec: 1, vmOpHashMap: 1, vmOpTry: 2, vmOpTryEnd: 0, vmOpSpecial: 1, vmOpFail: 1,
+}
+
+// vmProto is the bytecode of a top-level form or of an `*ExprFn` body, together with everything its instructions refer to.
+type vmProto struct {
+	code    []byte
+	consts  []Expr
+	globals []*atomic.Pointer[Expr] // see `Interpreter.globalRef`
+	sites   []vmSite
+}
+
+// vmSite is what the VM needs to know about a form (or ident) at run time, beyond its bytecode.
+type vmSite struct {
+	form  ExprList    // the call, `def`, `set` or special form
+	name  ExprIdent   // the ident, or the callee ident of the call
+	span  *SrcSpan    // to locate errors at, if known
+	tail  bool        // for calls: whether in tail position
+	isDef bool        // for `def` and `set`: whether `def`
+	sf    SpecialForm // for `vmOpSpecial`
+	catch []Expr      // for `try`: the `catch` clause
+}
+
+func (me *vmProto) operand(pc int, idx int) int {
+	pc += 1 + 2*idx
+	return int(me.code[pc]) | (int(me.code[pc+1]) << 8)
+}
+
+// compiled returns the `compiled` body of the `*ExprFn` that `me` is the bytecode of.
+func (me *vmProto) compiled() compiled {
+	return func(env *Env) (*Env, Expr, error) {
+		ret, err := vmRun(me, env)
+		return nil, ret, err
+	}
+}
+
+// vmEval evaluates `expr` in `env` by compiling it to bytecode and running that.
+func vmEval(env *Env, expr Expr) (Expr, error) {
+	return vmRun((&compiler{env: env, globals: fnEnv(env) == env.interp.Env}).assemble(expr, nil), env)
+}
+
+// specialFormAssemblers are to bytecode what `specialFormCompilers` are to `compiled` code.
+var specialFormAssemblers = map[uintptr]func(*assembler, ExprList, *SrcSpan, bool){}
+
+func init() {
+	specialFormAssemblers[funcAddr(stdDef)] = func(me *assembler, form ExprList, span *SrcSpan, tail bool) { me.defOrSet(true, form, span, tail) }
+	specialFormAssemblers[funcAddr(stdSet)] = func(me *assembler, form ExprList, span *SrcSpan, tail bool) { me.defOrSet(false, form, span, tail) }
+	specialFormAssemblers[funcAddr(stdIf)] = (*assembler).ifElse
+	specialFormAssemblers[funcAddr(stdLet)] = (*assembler).let
+	specialFormAssemblers[funcAddr(stdFn)] = (*assembler).fn
+	specialFormAssemblers[funcAddr(stdDo)] = (*assembler).do
+	specialFormAssemblers[funcAddr(stdQuote)] = (*assembler).quote
+	specialFormAssemblers[funcAddr(stdTryCatch)] = (*assembler).tryCatch
+}
+
+var errBytecodeTooLarge = errors.New("form too large to compile to bytecode")
+
+// assembler compiles to bytecode, with the same compile-time macro expansion, special form dispatch, constant folding
+// and name resolution as `compiled` code, and locating errors at the same `SrcSpan`s.
+type assembler struct {
+	*compiler
+	proto *vmProto
+	err   error // `errBytecodeTooLarge` if any operand did not fit
+}
+
+// assemble returns the bytecode for `expr`, locating errors in it at `span` if it has no `SrcSpan` of its own.
+func (me *compiler) assemble(expr Expr, span *SrcSpan) *vmProto {
+	asm := assembler{compiler: me, proto: &vmProto{}}
+	asm.expr(expr, span, true)
+	if asm.err != nil {
+		asm.proto = &vmProto{}
+		asm.fail(span, asm.err)
+	}
+	return asm.proto
+}
+
+// emit appends the instruction and returns its pc.
+func (me *assembler) emit(op vmOp, operands ...int) int {
+	pc := len(me.proto.code)
+	me.proto.code = append(me.proto.code, byte(op))
+	for range operands {
+		me.proto.code = append(me.proto.code, 0, 0)
+	}
+	for i, operand := range operands {
+		me.patch(pc, i, operand)
+	}
+	return pc
+}
+
+// patch sets the `idx`th operand of the instruction at `pc`.
+func (me *assembler) patch(pc int, idx int, operand int) {
+	if operand > math.MaxUint16 {
+		me.err = errBytecodeTooLarge
+	}
+	pc += 1 + 2*idx
+	me.proto.code[pc], me.proto.code[pc+1] = byte(operand), byte(operand>>8)
+}
+
+// patchHere sets the `idx`th operand of the jump-like instruction at `pc` to the pc of the next instruction.
+func (me *assembler) patchHere(pc int, idx int) {
+	me.patch(pc, idx, len(me.proto.code))
+}
+
+func (me *assembler) constant(expr Expr) int {
+	me.proto.consts = append(me.proto.consts, expr)
+	return len(me.proto.consts) - 1
+}
+
+func (me *assembler) site(site vmSite) int {
+	me.proto.sites = append(me.proto.sites, site)
+	return len(me.proto.sites) - 1
+}
+
+func (me *assembler) ret(tail bool) {
+	if tail {
+		me.emit(vmOpReturn)
+	}
+}
+
+// fail emits the failing with `err`, at `span`.
+func (me *assembler) fail(span *SrcSpan, err error) {
+	me.emit(vmOpFail, me.constant(ExprErr{It: errAtSpan(span, err)}))
+}
+
+// expr emits the evaluation of `expr`, locating errors in it at `span` if it has no `SrcSpan` of its own.
+// if `tail`, then also the return of its result (or for a call, the tail call).
+func (me *assembler) expr(expr Expr, span *SrcSpan, tail bool) {
+	switch it := expr.(type) {
+	case ExprIdent:
+		me.ident(it, span)
+	case ExprVec:
+		item_spans := itemSpans(srcSpanOf(it), len(it))
+		for i, item := range it {
+			me.expr(item, item_spans[i], false)
+		}
+		me.emit(vmOpVec, len(it))
+	case ExprHashMap:
+		hash_map_span, keys := srcSpanOf(it), make(ExprList, 0, len(it))
+		for key, value := range it {
+			keys = append(keys, ExprStr(key))
+			me.expr(value, hash_map_span, false)
+		}
+		me.emit(vmOpHashMap, me.constant(keys))
+	case ExprList:
+		if len(it) > 0 {
+			if own_span := srcSpanOf(it); own_span != nil {
+				span = own_span
+			}
+			me.list(it, span, tail)
+			return
+		}
+		me.emit(vmOpConst, me.constant(ExprList{}))
+	default:
+		me.emit(vmOpConst, me.constant(expr))
+	}
+	me.ret(tail)
+}
+
+func (me *assembler) ident(name ExprIdent, span *SrcSpan) {
+	site := me.site(vmSite{name: name, span: span})
+	if depth, idx, ref := me.resolve(name); idx >= 0 {
+		me.emit(vmOpSlot, depth, idx, site)
+	} else if ref != nil {
+		me.proto.globals = append(me.proto.globals, ref)
+		me.emit(vmOpGlobal, len(me.proto.globals)-1, site)
+	} else {
+		me.emit(vmOpLookup, site)
+	}
+}
+
+func (me *assembler) list(form ExprList, span *SrcSpan, tail bool) {
+	expansion, sf, err := me.headOf(form)
+	if err != nil {
+		me.fail(span, err)
+	} else if expansion != nil {
+		me.expr(expansion, span, tail)
+	} else if sf == nil {
+		me.call(form, span, tail)
+	} else if assemble := specialFormAssemblers[funcAddr(sf)]; assemble != nil {
+		assemble(me, form, span, tail)
+	} else {
+		if me.scope != nil {
+			me.scope.opaque = true // as `sf` might bind anything in the `Env` it gets
+		}
+		me.emit(vmOpSpecial, me.site(vmSite{form: form, span: span, sf: sf}))
+		me.ret(tail)
+	}
+}
+
+func (me *assembler) call(form ExprList, span *SrcSpan, tail bool) {
+	name, _ := form[0].(ExprIdent)
+	item_spans := itemSpans(span, len(form))
+	site := me.site(vmSite{form: form, name: name, span: span, tail: tail})
+	me.expr(form[0], item_spans[0], false)
+	macro_check := -1
+	if name != "" { // a macro not known as such at compile time gets expanded at run time
+		macro_check = me.emit(vmOpMacroCheck, site, 0)
+	}
+	for i, arg := range form[1:] {
+		me.expr(arg, item_spans[i+1], false)
+	}
+	if tail {
+		me.emit(vmOpTailCall, site)
+	} else {
+		me.emit(vmOpCall, site)
+	}
+	if macro_check >= 0 {
+		me.patchHere(macro_check, 1)
+	}
+}
+
+func (me *assembler) quote(form ExprList, span *SrcSpan, tail bool) {
+	if err := checkArgsCount(1, 1, "`quote`", form[1:]); err != nil {
+		me.fail(span, err)
+		return
+	}
+	me.emit(vmOpConst, me.constant(form[1]))
+	me.ret(tail)
+}
+
+func (me *assembler) do(form ExprList, span *SrcSpan, tail bool) {
+	if err := checkArgsCount(1, -1, "`do`", form[1:]); err != nil {
+		me.fail(span, err)
+		return
+	}
+	for _, expr := range form[1 : len(form)-1] {
+		if !isConstForm(expr) { // as those have no effects
+			me.expr(expr, span, false)
+			me.emit(vmOpPop)
+		}
+	}
+	me.expr(form[len(form)-1], nil, tail)
+}
+
+func (me *assembler) ifElse(form ExprList, span *SrcSpan, tail bool) {
+	args := form[1:]
+	if err := checkArgsCount(2, 3, "`if`", args); err != nil {
+		me.fail(span, err)
+		return
+	}
+	if len(args) < 3 {
+		args = append(args, exprNil)
+	}
+	if isConstForm(args[0]) { // no need to ever check it again then
+		if isNilOrFalse(args[0]) {
+			me.expr(args[2], nil, tail)
+		} else {
+			me.expr(args[1], nil, tail)
+		}
+		return
+	}
+	me.expr(args[0], span, false)
+	jump_if_not := me.emit(vmOpJumpIfNot, 0)
+	me.expr(args[1], nil, tail)
+	jump := -1
+	if !tail { // else, it returned already
+		jump = me.emit(vmOpJump, 0)
+	}
+	me.patchHere(jump_if_not, 0)
+	me.expr(args[2], nil, tail)
+	if jump >= 0 {
+		me.patchHere(jump, 0)
+	}
+}
+
+func (me *assembler) defOrSet(isDef bool, form ExprList, span *SrcSpan, tail bool) {
+	args := form[1:]
+	site := me.site(vmSite{form: form, span: span, isDef: isDef})
+	me.emit(vmOpDefCheck, site)
+	if len(args) == 2 { // else, `vmOpDefCheck` fails
+		if maybe_macro, is_macro, _ := isListStartingWithIdent(args[1], exprIdentMacro, -1); is_macro {
+			me.fnFrom(maybe_macro[1:], span, true)
+		} else {
+			me.expr(args[1], span, false)
+		}
+		if name, _ := args[0].(ExprIdent); name != "" {
+			me.scope.bind(name)
+		}
+		me.emit(vmOpDef, site)
+	}
+	me.ret(tail)
+}
+
+func (me *assembler) let(form ExprList, span *SrcSpan, tail bool) {
+	names, exprs, err := letBindings(me.env.interp, form[1:])
+	if err != nil {
+		me.fail(span, err)
+		return
+	}
+	let_scope := &scope{parent: me.scope, names: make([]Expr, len(names))}
+	for i, name := range names {
+		let_scope.names[i] = name
+	}
+	me.scope = let_scope
+	defer func() { me.scope = let_scope.parent }()
+	me.emit(vmOpLet, me.constant(ExprList(let_scope.names)))
+	for i, expr := range exprs {
+		me.expr(expr, span, false)
+		me.emit(vmOpLetBind, let_scope.slotOf(names[i]))
+	}
+	me.do(append(ExprList{exprIdentDo}, form[2:]...), span, tail)
+	if !tail { // else, it returned already
+		me.emit(vmOpLetEnd)
+	}
+}
+
+func (me *assembler) fn(form ExprList, span *SrcSpan, tail bool) {
+	me.fnFrom(form[1:], span, false)
+	me.ret(tail)
+}
+
+func (me *assembler) fnFrom(args []Expr, span *SrcSpan, isMacro bool) {
+	fn, err := fnFrom(args)
+	if err != nil {
+		me.fail(span, err)
+		return
+	}
+	fn.isMacro, fn.code.scope, fn.code.bytecode = isMacro, me.scope, true
+	me.emit(vmOpClosure, me.constant(fn))
+}
+
+func (me *assembler) tryCatch(form ExprList, span *SrcSpan, tail bool) {
+	catch, err := tryCatchClause(me.env.interp, form[1:])
+	if err != nil {
+		me.fail(span, err)
+		return
+	} else if catch == nil {
+		me.expr(form[1], nil, tail)
+		return
+	}
+	try := me.emit(vmOpTry, me.site(vmSite{span: span, catch: catch}), 0)
+	me.expr(form[1], nil, false) // not `tail`, as the `catch` needs the frame
+	me.emit(vmOpTryEnd)
+	jump := -1
+	if tail {
+		me.emit(vmOpReturn)
+	} else {
+		jump = me.emit(vmOpJump, 0)
+	}
+	me.patchHere(try, 1)
+	for _, name := range catch[1 : len(catch)-1] {
+		me.scope.bind(name.(ExprIdent))
+	}
+	me.expr(catch[len(catch)-1], nil, tail)
+	if jump >= 0 {
+		me.patchHere(jump, 0)
+	}
+}
@@ -0,0 +1,45 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// errIfCanceled returns the error for `ctx` being done, or `nil` if it isn't (yet).
+func errIfCanceled(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("evaluation canceled: %w", context.Cause(ctx))
+	default:
+		return nil
+	}
+}
+
+// stdWithTimeout evaluates its body, but cancels that evaluation with an error after some milliseconds: `(withTimeout 1000 (foo))`.
+// this covers all Lisp calls within (also in `future`s started in there, which get canceled when it returns, and those
+// of `fn`s by Go builtins such as `apply`, `atomSwap` or `pmap`), and the waiting of `chanSend`, `chanRecv` and `await`.
+func stdWithTimeout(env *Env, args []Expr) (*Env, Expr, error) {
+	if err := checkArgsCount(2, -1, "`withTimeout`", args); err != nil {
+		return nil, nil, err
+	}
+	expr, err := evalAndApply(env, args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout, err := checkIs[ExprNum](expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	body := args[1]
+	if len(args) > 2 {
+		body = append(ExprList{exprIdentDo}, args[1:]...)
+	}
+	ctx, cancel := context.WithTimeout(env.context(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	expr, err = evalAndApply(newCtxEnv(env, ctx), body)
+	return nil, expr, err
+}
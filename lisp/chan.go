@@ -0,0 +1,203 @@
+package lisp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ExprChan is a channel of `Expr`s, made via `chanNew`. receiving from a closed one gives `:nil`.
+type ExprChan chan Expr
+
+func (ExprChan) isExpr() {}
+
+var errChanClosed = errors.New("channel is closed")
+
+// stdGo calls a function on a new goroutine: `(go foo arg1 arg2)`. any error it returns (or panic) is written to `Stderr`.
+func (me *Interpreter) stdGo(args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, -1, "`go`", args); err != nil {
+		return nil, err
+	}
+	fn, err := checkIsCallable(args[0])
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if _, err := recovering(func() (Expr, error) { return fn(args[1:]) }); err != nil {
+			_, _ = io.WriteString(me.Stderr, "error in `go` call: "+ErrStackTrace(err)+"\n")
+		}
+	}()
+	return exprNil, nil
+}
+
+// stdChanNew makes an unbuffered channel, or a buffered one for `(chanNew bufferSize)`.
+func stdChanNew(args []Expr) (Expr, error) {
+	if err := checkArgsCount(0, 1, "`chanNew`", args); err != nil {
+		return nil, err
+	}
+	var buf_size ExprNum
+	if len(args) > 0 {
+		var err error
+		if buf_size, err = checkIs[ExprNum](args[0]); err != nil {
+			return nil, err
+		} else if buf_size < 0 {
+			return nil, fmt.Errorf("invalid channel buffer size %d", buf_size)
+		}
+	}
+	return make(ExprChan, buf_size), nil
+}
+
+func init() {
+	builtinsInEnv[funcAddr(stdChanSend)] = chanSend
+	builtinsInEnv[funcAddr(stdChanRecv)] = chanRecv
+}
+
+func stdChanSend(args []Expr) (Expr, error) { return chanSend(nil, args) }
+func stdChanRecv(args []Expr) (Expr, error) { return chanRecv(nil, args) }
+
+// chanSend is `chanSend`, which blocks until the value is sent or the `context` of `env` is done.
+func chanSend(env *Env, args []Expr) (ret Expr, err error) {
+	if err = checkArgsCount(2, 2, "`chanSend`", args); err != nil {
+		return nil, err
+	}
+	ch, err := checkIs[ExprChan](args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if recover() != nil { // sending on a closed channel is the only panic possible here
+			ret, err = nil, errChanClosed
+		}
+	}()
+	ctx := env.context()
+	select {
+	case ch <- args[1]:
+		return exprNil, nil
+	case <-ctx.Done():
+		return nil, errIfCanceled(ctx)
+	}
+}
+
+// chanRecv is `chanRecv`, which blocks until a value is received (or the channel closed) or the `context` of `env` is done.
+func chanRecv(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 1, "`chanRecv`", args); err != nil {
+		return nil, err
+	}
+	ch, err := checkIs[ExprChan](args[0])
+	if err != nil {
+		return nil, err
+	}
+	ctx := env.context()
+	select {
+	case expr, ok := <-ch:
+		if ok {
+			return expr, nil
+		}
+		return exprNil, nil
+	case <-ctx.Done():
+		return nil, errIfCanceled(ctx)
+	}
+}
+
+func stdChanClose(args []Expr) (ret Expr, err error) {
+	if err = checkArgsCount(1, 1, "`chanClose`", args); err != nil {
+		return nil, err
+	}
+	ch, err := checkIs[ExprChan](args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if recover() != nil { // closing a closed channel is the only panic possible here
+			ret, err = nil, errChanClosed
+		}
+	}()
+	close(ch)
+	return exprNil, nil
+}
+
+// stdSelect waits for the first of several channel operations that can proceed, like Go's `select`:
+//
+//	(select
+//		(chanRecv ch1 received body...)
+//		(chanSend ch2 value body...)
+//		(default body...))
+//
+// then evaluates the `body` of that clause, with `received` bound to the value received (`:nil` if `ch1` was closed).
+// without a `body`, the result is `received` or `:nil`.
+func stdSelect(env *Env, args []Expr) (*Env, Expr, error) {
+	if err := checkArgsCount(1, -1, "`select`", args); err != nil {
+		return nil, nil, err
+	}
+	cases, bodies := make([]reflect.SelectCase, len(args)), make([][]Expr, len(args))
+	for i, arg := range args {
+		clause, err := checkIs[ExprList](arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		var kind ExprIdent
+		if len(clause) > 0 {
+			kind, _ = clause[0].(ExprIdent)
+		}
+		if kind == "default" {
+			cases[i].Dir, bodies[i] = reflect.SelectDefault, clause[1:]
+			continue
+		} else if (kind != "chanRecv") && (kind != "chanSend") {
+			return nil, nil, fmt.Errorf("expected `(chanRecv ch name body...)`, `(chanSend ch value body...)` or `(default body...)` in `select`, instead of `%s`", str(true, clause))
+		} else if err = checkArgsCount(3, -1, "form `"+string(kind)+"` in `select`", clause); err != nil {
+			return nil, nil, err
+		}
+		expr, err := evalAndApply(env, clause[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		ch, err := checkIs[ExprChan](expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		cases[i].Chan, bodies[i] = reflect.ValueOf(ch), clause[3:]
+		if kind == "chanRecv" {
+			if _, err = checkIs[ExprIdent](clause[2]); err != nil {
+				return nil, nil, err
+			}
+			cases[i].Dir = reflect.SelectRecv
+		} else {
+			if expr, err = evalAndApply(env, clause[2]); err != nil {
+				return nil, nil, err
+			}
+			cases[i].Dir, cases[i].Send = reflect.SelectSend, reflect.ValueOf(&expr).Elem()
+		}
+	}
+
+	ctx := env.context()
+	idx, received, err := selectCase(append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}))
+	if err != nil {
+		return nil, nil, err
+	} else if idx == len(cases) {
+		return nil, nil, errIfCanceled(ctx)
+	}
+	if cases[idx].Dir == reflect.SelectRecv {
+		env = newEnv(env, []Expr{args[idx].(ExprList)[2]}, []Expr{received})
+	}
+	if len(bodies[idx]) == 0 {
+		return nil, received, nil
+	}
+	return stdDo(env, bodies[idx])
+}
+
+// selectCase is `reflect.Select` but with `:nil` (rather than a zero `reflect.Value`) for
+// no value received, and with an error (rather than a panic) for sending on a closed channel.
+func selectCase(cases []reflect.SelectCase) (idx int, received Expr, err error) {
+	defer func() {
+		if recover() != nil {
+			err = errChanClosed
+		}
+	}()
+	idx, value, ok := reflect.Select(cases)
+	received = exprNil
+	if ok {
+		received = value.Interface().(Expr)
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEval reads and evaluates the single form in `src` with `interp`, and returns its result as printed (or else its error).
+func testEval(interp *Interpreter, src string) string {
+	expr, err := interp.ReadAndEval("", src)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return str(true, expr)
+}
+
+// testEvalAll evaluates each key of `want` in turn with `interp`, and fails `t` for those not resulting in their value.
+func testEvalAll(t *testing.T, interp *Interpreter, want [][2]string) {
+	t.Helper()
+	for _, it := range want {
+		if have := testEval(interp, it[0]); have != it[1] {
+			t.Errorf("%s\n    expected: %s\n    got: %s", it[0], it[1], have)
+		}
+	}
+}
+
+// testStderr is an `Interpreter.Stderr` for goroutines to write to, that signals each write on `written`.
+type testStderr struct {
+	sync.Mutex
+	buf     strings.Builder
+	written chan struct{}
+}
+
+func (me *testStderr) Write(p []byte) (int, error) {
+	me.Lock()
+	defer me.Unlock()
+	n, err := me.buf.Write(p)
+	me.written <- struct{}{}
+	return n, err
+}
+
+func TestChan(t *testing.T) {
+	testEvalAll(t, NewInterpreter(Options{}), [][2]string{
+		{"(def ch (chanNew 2))", "<chan 0/2>"},
+		{"(chanSend ch 1)", ":nil"},
+		{"(chanSend ch (list 2 3))", ":nil"},
+		{"(list (chanRecv ch) (chanRecv ch))", "(1 (2 3))"},
+		{"(chanClose ch)", ":nil"},
+		{"(chanRecv ch)", ":nil"},
+		{"(chanSend ch 4)", "error: 1:1: channel is closed"},
+		{"(chanClose ch)", "error: 1:1: channel is closed"},
+		{"(chanNew -1)", "error: 1:1: invalid channel buffer size -1"},
+	})
+}
+
+func TestSelect(t *testing.T) {
+	testEvalAll(t, NewInterpreter(Options{}), [][2]string{
+		{"(def ch (chanNew 1))", "<chan 0/1>"},
+		{"(select (chanRecv ch it it) (default :none))", ":none"},
+		{"(select (chanSend ch 12 :sent) (default :none))", ":sent"},
+		{"(select (chanSend ch 34 :sent) (default :none))", ":none"},
+		{"(select (chanRecv ch it (+ it 1)))", "13"},
+		{"(select (chanRecv ch))", "error: 1:1: form `chanRecv` in `select` expects at least 3 arg(s), not 2"},
+		{"(do (chanClose ch) (select (chanRecv ch it it)))", ":nil"},
+		{"(select (chanSend ch 56))", "error: 1:1: channel is closed"},
+	})
+}
+
+func TestGo(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		interp := NewInterpreter(Options{Bytecode: bytecode, MalCompat: true})
+		stderr := &testStderr{written: make(chan struct{}, 1)}
+		interp.Stderr = stderr
+		testEvalAll(t, interp, [][2]string{
+			{"(def ch (chanNew))", "<chan 0/0>"},
+			{"(go (fn (n) (chanSend ch (* n 2))) 21)", ":nil"},
+			{"(chanRecv ch)", "42"},
+			{"(go (fn () (throw \"oops\")))", ":nil"},
+		})
+		<-stderr.written
+		testEvalAll(t, interp, [][2]string{
+			{"(go (fn () (meta +)))", ":nil"}, // panics in Go, as a builtin is not hashable
+		})
+		<-stderr.written
+		stderr.Lock()
+		if output := stderr.buf.String(); !(strings.Contains(output, "error in `go` call: 1:12: oops") && strings.Contains(output, "error in `go` call: panic: ")) {
+			t.Errorf("bytecode=%v: unexpected Stderr output: %s", bytecode, output)
+		}
+		stderr.Unlock()
+	}
+}
+
+// TestBlockingCanceled checks that the builtins that block until some other goroutine acts stop once their evaluation is canceled,
+// such as by `withTimeout` or (as by Ctrl+C in the `Repl`) the `context.Context` of `EvalContext`.
+func TestBlockingCanceled(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	testEvalAll(t, interp, [][2]string{
+		{"(def full (chanNew 1))", "<chan 0/1>"},
+		{"(chanSend full 1)", ":nil"},
+		{"(withTimeout 10 (chanRecv (chanNew)))", "error: 1:17: evaluation canceled: context deadline exceeded"},
+		{"(withTimeout 10 (chanSend full 2))", "error: 1:17: evaluation canceled: context deadline exceeded"},
+		{"(withTimeout 10 (await (promise)))", "error: 1:17: evaluation canceled: context deadline exceeded"},
+		{"(withTimeout 1000 (await (promise) 10 :timedOut))", ":timedOut"},
+		{"(do (def ready (chanNew 1)) (chanSend ready 3) (withTimeout 1000 (chanRecv ready)))", "3"},
+	})
+	for _, src := range []string{"(chanRecv (chanNew))", "(chanSend full 2)", "(await (promise))"} {
+		expr, err := readExpr("", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancelCause(context.Background())
+		time.AfterFunc(10*time.Millisecond, func() { cancel(errInterrupted) })
+		if _, err = interp.EvalContext(ctx, expr); !errors.Is(err, errInterrupted) {
+			t.Errorf("%s: expected an interruption, got: %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package lisp
+
+import (
+	"reflect"
+	"slices"
+	"sync/atomic"
+)
+
+// compiled is what the body of an `*ExprFn` gets turned into on its first call: a tree of Go closures with all
+// macros already expanded, all special forms already dispatched and all constant forms already folded, so that
+// `evalAndApply` no longer has to re-analyze the body on every call. like a `SpecialForm`, it returns a non-`nil`
+// `*Env` if the returned `Expr` still needs evaluating in it (in the TCO loop of `evalAndApply`), such as a `*tailCall`.
+type compiled func(*Env) (*Env, Expr, error)
+
+func (compiled) isExpr() {}
+
+// tailCall is a call whose callee and args are evaluated already, returned by `compiled` code for `evalAndApply` to make.
+type tailCall struct {
+	callee Expr
+	args   []Expr
+	name   string   // the callee's name at the call-site if known, else ""
+	form   Expr     // the call form, to locate errors if `span` is `nil`
+	span   *SrcSpan // the call-site's source location if known, else `nil`
+}
+
+func (*tailCall) isExpr() {}
+
+func (me *tailCall) srcSpan() *SrcSpan {
+	if me.span == nil {
+		return srcSpanOf(me.form)
+	}
+	return me.span
+}
+
+// fnCode is the compiled body shared by all `*ExprFn`s made from the same `fn` form.
+type fnCode struct {
+	body      atomic.Pointer[fnBody]
+	compiling atomic.Bool
+	scope     *scope   // where the `fn` form is in compiled code, if it is
+	bytecode  bool     // whether the `fn` form is in bytecode, so that `body` is to be too
+	proto     *vmProto // if `bytecode`, set before `body` is
+}
+
+// fnBody is a compiled `fnCode` body, with the `Interpreter.macroDefs` count it was compiled at: as it has all macro calls
+// expanded, it is compiled anew once a macro has been (re)defined since.
+type fnBody struct {
+	compiled
+	macroDefs int64
+}
+
+// compiled returns the compiled body of `me`, or `nil` while that is still being compiled (by another goroutine, or
+// re-entrantly by some macro that calls `me` while being expanded in its body) or while debugging. then, the body is to be interpreted.
+func (me *ExprFn) compiled() compiled {
+	macro_defs := me.env.interp.macroDefs.Load()
+	if me.env.interp.interpretOnly() { // so all of the body gets to `evalAndApply`, for the `Debugger` or `Coverage` to see its every form
+		return nil
+	} else if body := me.code.body.Load(); (body != nil) && (body.macroDefs == macro_defs) {
+		return body.compiled
+	} else if !me.code.compiling.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer me.code.compiling.Store(false)
+	env := me.env // after the below loop, the `Env` that all of the compiled code's `Env`s are children of
+	for it := me.code.scope; it != nil; it = it.parent {
+		env = env.Parent
+	}
+	compiler := &compiler{env: me.env, scope: &scope{parent: me.code.scope, names: me.params}, globals: fnEnv(env) == env.interp.Env}
+	var body compiled
+	if me.code.bytecode {
+		proto := compiler.assemble(me.body, nil)
+		me.code.proto, body = proto, proto.compiled()
+	} else {
+		body = compiler.compile(me.body)
+	}
+	me.code.body.Store(&fnBody{compiled: body, macroDefs: macro_defs})
+	return body
+}
+
+// eval evaluates all of `me`: first itself, then in `evalAndApply` whatever it returns for further evaluation.
+func (me compiled) eval(env *Env) (Expr, error) {
+	tail_env, expr, err := me(env)
+	if (err != nil) || (tail_env == nil) {
+		return expr, err
+	}
+	return evalAndApply(tail_env, expr)
+}
+
+// evalOr is `me.eval(env)`, or if `me` is `nil`, the interpretation of `expr` instead.
+func (me compiled) evalOr(env *Env, expr Expr) (Expr, error) {
+	if me == nil {
+		return evalAndApply(env, expr)
+	}
+	return me.eval(env)
+}
+
+// specialFormCompilers compile the calls of the `SpecialForm`s they are keyed by the code addresses of, so that
+// also aliases (such as the `def!` for `def` of `Options.MalCompat`) get compiled. any other special forms are
+// called as-is at run time.
+var specialFormCompilers = map[uintptr]func(*compiler, ExprList, *SrcSpan) compiled{}
+
+// specialFormRewrites rewrite the calls of the `SpecialForm`s they are keyed by the code addresses of into other
+// forms, which are compiled instead. for special forms that do nothing but such a rewrite, like `cond`.
+var specialFormRewrites = map[uintptr]func([]Expr) (Expr, error){}
+
+func init() {
+	specialFormCompilers[funcAddr(stdDef)] = func(me *compiler, form ExprList, span *SrcSpan) compiled { return me.compileDefOrSet(true, form, span) }
+	specialFormCompilers[funcAddr(stdSet)] = func(me *compiler, form ExprList, span *SrcSpan) compiled {
+		return me.compileDefOrSet(false, form, span)
+	}
+	specialFormCompilers[funcAddr(stdIf)] = (*compiler).compileIf
+	specialFormCompilers[funcAddr(stdLet)] = (*compiler).compileLet
+	specialFormCompilers[funcAddr(stdFn)] = (*compiler).compileFn
+	specialFormCompilers[funcAddr(stdDo)] = (*compiler).compileDo
+	specialFormCompilers[funcAddr(stdQuote)] = (*compiler).compileQuote
+	specialFormCompilers[funcAddr(stdTryCatch)] = (*compiler).compileTryCatch
+}
+
+func funcAddr(fn any) uintptr { return reflect.ValueOf(fn).Pointer() }
+
+type compiler struct {
+	env     *Env   // the env of the `*ExprFn` being compiled, for finding macros
+	scope   *scope // the innermost one at the current point of compilation
+	globals bool   // whether the root `Env` is the parent of the `Env` of the outermost `scope`
+}
+
+// scope is the compile-time view of the `Env` that some compiled code runs in: a `fn` call's or a `let`'s.
+// the names bound in it shadow any macros of the same names.
+type scope struct {
+	parent  *scope
+	names   []Expr      // the `ExprIdent`s of the `Env.slots`
+	dynamic []ExprIdent // names that compiled `def`s, `set`s and `catch`es bind in the `Env.Map` (from the current point of compilation on)
+	opaque  bool        // whether (from the current point of compilation on) other code might bind any names in the `Env.Map`
+}
+
+func (me *scope) slotOf(name ExprIdent) int {
+	return slices.Index(me.names, Expr(name))
+}
+
+// bind records that `name` gets bound at the current point of compilation (if not a slot, then in the `Env.Map`).
+// a `nil` `scope` is that of top-level bytecode, where nothing is resolved to slots anyway.
+func (me *scope) bind(name ExprIdent) {
+	if (me != nil) && (me.slotOf(name) < 0) && !slices.Contains(me.dynamic, name) {
+		me.dynamic = append(me.dynamic, name)
+	}
+}
+
+func (me *compiler) isLocal(name ExprIdent) bool {
+	for it := me.scope; it != nil; it = it.parent {
+		if (it.slotOf(name) >= 0) || slices.Contains(it.dynamic, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileIdent resolves `name` at compile time: to a slot of an `Env` up the chain if it is one there (and not possibly
+// shadowed by a `Map` entry of any `Env` further down), else to a cached global if not bound in any compiled code's `Env`.
+// only otherwise is it looked up by name (in all the `Env`s up the chain) every time it is evaluated.
+func (me *compiler) compileIdent(name ExprIdent) compiled {
+	lookup := func(env *Env) (*Env, Expr, error) {
+		found, err := env.get(name)
+		return nil, found, err
+	}
+	depth, idx, ref := me.resolve(name)
+	if idx >= 0 {
+		return func(env *Env) (*Env, Expr, error) {
+			for range depth {
+				env = env.Parent
+			}
+			if found := env.slots[idx].Load(); found != nil {
+				return nil, *found, nil
+			}
+			return lookup(env.Parent) // a `let` binding referred to by an earlier one (or by itself)
+		}
+	} else if ref == nil {
+		return lookup
+	}
+	return func(env *Env) (*Env, Expr, error) {
+		if found := ref.Load(); found != nil {
+			return nil, *found, nil
+		}
+		return lookup(env) // for the error, or for a new entry added to the root `Env.Map` directly
+	}
+}
+
+// resolve tells how `name` is to be looked up for `compileIdent`: in slot `idx` of the `Env` `depth` levels up the
+// chain if `idx >= 0`, else in the global `ref` if not `nil`, else by name.
+func (me *compiler) resolve(name ExprIdent) (depth int, idx int, ref *atomic.Pointer[Expr]) {
+	for it := me.scope; it != nil; it, depth = it.parent, depth+1 {
+		if it.opaque || slices.Contains(it.dynamic, name) {
+			return 0, -1, nil
+		} else if idx = it.slotOf(name); idx >= 0 {
+			return depth, idx, nil
+		}
+	}
+	if me.globals {
+		ref = me.env.interp.globalRef(name)
+	}
+	return 0, -1, ref
+}
+
+func (me *compiler) compile(expr Expr) compiled {
+	return me.compileAt(expr, nil)
+}
+
+// compileAt compiles `expr`, locating errors in it at `span` if it has no `SrcSpan` of its own.
+func (me *compiler) compileAt(expr Expr, span *SrcSpan) compiled {
+	switch it := expr.(type) {
+	case ExprIdent:
+		return me.compileIdent(it)
+	case ExprVec:
+		return me.compileVec(it)
+	case ExprHashMap:
+		return me.compileHashMap(it)
+	case ExprList:
+		if len(it) > 0 {
+			if own_span := srcSpanOf(it); own_span != nil {
+				span = own_span
+			}
+			return me.compileList(it, span)
+		}
+		return compiledConst(ExprList{})
+	}
+	return compiledConst(expr)
+}
+
+func compiledConst(expr Expr) compiled {
+	return func(*Env) (*Env, Expr, error) { return nil, expr, nil }
+}
+
+func compiledErr(span *SrcSpan, err error) compiled {
+	err = errAtSpan(span, err)
+	return func(*Env) (*Env, Expr, error) { return nil, nil, err }
+}
+
+// headOf tells what the non-empty `form` is to be compiled as: the `expansion` if a macro call or a rewritten special
+// form call (see `specialFormRewrites`), else a call of the special form `sf` if not `nil`, else a call.
+func (me *compiler) headOf(form ExprList) (expansion Expr, sf SpecialForm, err error) {
+	ident, _ := form[0].(ExprIdent)
+	if (ident == "") || me.isLocal(ident) {
+		return nil, nil, nil
+	}
+	if maybe_fn := me.env.find(ident); maybe_fn != nil {
+		if fn, _ := maybe_fn.(*ExprFn); (fn != nil) && fn.isMacro {
+			expansion, err = fn.Call(form[1:])
+			return
+		}
+	}
+	if sf = me.env.interp.specialForms[ident]; sf != nil {
+		if rewrite := specialFormRewrites[funcAddr(sf)]; rewrite != nil {
+			expansion, err = rewrite(form[1:])
+			return expansion, nil, err
+		}
+	}
+	return nil, sf, nil
+}
+
+func (me *compiler) compileList(form ExprList, span *SrcSpan) compiled {
+	expansion, sf, err := me.headOf(form)
+	if err != nil {
+		return compiledErr(span, err)
+	} else if expansion != nil {
+		return me.compileAt(expansion, span)
+	} else if sf != nil {
+		if compile := specialFormCompilers[funcAddr(sf)]; compile != nil {
+			return compile(me, form, span)
+		}
+		args := form[1:]
+		me.scope.opaque = true // as `sf` might bind anything in the `Env` it gets
+		return func(env *Env) (*Env, Expr, error) {
+			tail_env, expr, err := sf(env, args)
+			return tail_env, expr, errAtSpan(span, err)
+		}
+	}
+	return me.compileCall(form, span)
+}
+
+func (me *compiler) compileCall(form ExprList, span *SrcSpan) compiled {
+	name, _ := form[0].(ExprIdent)
+	callee, args := me.compile(form[0]), me.compileAll(form[1:])
+	item_spans := itemSpans(span, len(form))
+	return func(env *Env) (*Env, Expr, error) {
+		fn, err := callee.eval(env)
+		if err != nil {
+			return nil, nil, errAtSpan(item_spans[0], err)
+		} else if it, _ := fn.(*ExprFn); (it != nil) && it.isMacro { // a macro not known as such at compile time: so expand at run time
+			return env, form, nil
+		}
+		call := tailCall{callee: fn, args: make([]Expr, len(args)), name: string(name), span: span}
+		for i, arg := range args {
+			if call.args[i], err = arg.eval(env); err != nil {
+				return nil, nil, errAtSpan(item_spans[i+1], err)
+			}
+		}
+		if it, is := fn.(ExprFunc); is {
+			ret, err := env.interp.callFunc(env, it, &call)
+			return nil, ret, err
+		}
+		return env, &call, nil
+	}
+}
+
+// itemSpans returns the `SrcSpan`s of the `numItems` items of the form at `span`, or `span` for those not known.
+func itemSpans(span *SrcSpan, numItems int) []*SrcSpan {
+	ret := make([]*SrcSpan, numItems)
+	for i := range ret {
+		if ret[i] = span; (span != nil) && (i < len(span.Items)) {
+			ret[i] = span.Items[i]
+		}
+	}
+	return ret
+}
+
+func (me *compiler) compileAll(exprs []Expr) []compiled {
+	ret := make([]compiled, len(exprs))
+	for i, expr := range exprs {
+		ret[i] = me.compile(expr)
+	}
+	return ret
+}
+
+func (me *compiler) compileVec(vec ExprVec) compiled {
+	item_spans, items := itemSpans(srcSpanOf(vec), len(vec)), me.compileAll(vec)
+	return func(env *Env) (_ *Env, _ Expr, err error) {
+		vec := make(ExprVec, len(items))
+		for i, item := range items {
+			if vec[i], err = item.eval(env); err != nil {
+				return nil, nil, errAtSpan(item_spans[i], err)
+			}
+		}
+		if env.interp.sandbox != nil {
+			if err = env.interp.sandboxAlloc(env.context(), vec); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, vec, nil
+	}
+}
+
+func (me *compiler) compileHashMap(hashMap ExprHashMap) compiled {
+	span, items := srcSpanOf(hashMap), make(map[string]compiled, len(hashMap))
+	for key, value := range hashMap {
+		items[key] = me.compile(value)
+	}
+	return func(env *Env) (_ *Env, _ Expr, err error) {
+		hash_map := make(ExprHashMap, len(items))
+		for key, value := range items {
+			if hash_map[key], err = value.eval(env); err != nil {
+				return nil, nil, errAtSpan(span, err)
+			}
+		}
+		if env.interp.sandbox != nil {
+			if err = env.interp.sandboxAlloc(env.context(), hash_map); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, hash_map, nil
+	}
+}
+
+func (me *compiler) compileQuote(form ExprList, span *SrcSpan) compiled {
+	if err := checkArgsCount(1, 1, "`quote`", form[1:]); err != nil {
+		return compiledErr(span, err)
+	}
+	return compiledConst(form[1])
+}
+
+func (me *compiler) compileDo(form ExprList, span *SrcSpan) compiled {
+	if err := checkArgsCount(1, -1, "`do`", form[1:]); err != nil {
+		return compiledErr(span, err)
+	}
+	var init []compiled
+	for _, expr := range form[1 : len(form)-1] {
+		if !isConstForm(expr) { // as those have no effects
+			init = append(init, me.compile(expr))
+		}
+	}
+	last := me.compile(form[len(form)-1])
+	if len(init) == 0 {
+		return last
+	}
+	return func(env *Env) (*Env, Expr, error) {
+		for _, expr := range init {
+			if _, err := expr.eval(env); err != nil {
+				return nil, nil, errAtSpan(span, err)
+			}
+		}
+		return last(env)
+	}
+}
+
+func (me *compiler) compileIf(form ExprList, span *SrcSpan) compiled {
+	args := form[1:]
+	if err := checkArgsCount(2, 3, "`if`", args); err != nil {
+		return compiledErr(span, err)
+	}
+	if len(args) < 3 {
+		args = append(args, exprNil)
+	}
+	if isConstForm(args[0]) { // no need to ever check it again then
+		if isNilOrFalse(args[0]) {
+			return me.compile(args[2])
+		}
+		return me.compile(args[1])
+	}
+	cond, then, otherwise := me.compile(args[0]), me.compile(args[1]), me.compile(args[2])
+	return func(env *Env) (*Env, Expr, error) {
+		expr, err := cond.eval(env)
+		if err != nil {
+			return nil, nil, errAtSpan(span, err)
+		} else if isNilOrFalse(expr) {
+			return otherwise(env)
+		}
+		return then(env)
+	}
+}
+
+func (me *compiler) compileDefOrSet(isDef bool, form ExprList, span *SrcSpan) compiled {
+	args := form[1:]
+	var value compiled
+	if len(args) == 2 {
+		if _, is_macro, _ := isListStartingWithIdent(args[1], exprIdentMacro, -1); !is_macro {
+			value = me.compile(args[1])
+		}
+		if name, _ := args[0].(ExprIdent); name != "" {
+			me.scope.bind(name)
+		}
+	}
+	return func(env *Env) (*Env, Expr, error) {
+		tail_env, expr, err := defOrSetWith(isDef, env, args, value)
+		return tail_env, expr, errAtSpan(span, err)
+	}
+}
+
+func (me *compiler) compileLet(form ExprList, span *SrcSpan) compiled {
+	names, exprs, err := letBindings(me.env.interp, form[1:])
+	if err != nil {
+		return compiledErr(span, err)
+	}
+	let_scope := &scope{parent: me.scope, names: make([]Expr, len(names))}
+	for i, name := range names {
+		let_scope.names[i] = name
+	}
+	me.scope = let_scope
+	defer func() { me.scope = let_scope.parent }()
+	values, slots := make([]compiled, len(exprs)), make([]int, len(names))
+	for i, expr := range exprs {
+		values[i], slots[i] = me.compile(expr), let_scope.slotOf(names[i])
+	}
+	body := me.compileDo(append(ExprList{exprIdentDo}, form[2:]...), span)
+	return func(env *Env) (*Env, Expr, error) {
+		let_env := newSlotsEnv(env, let_scope.names, nil)
+		for i, value := range values {
+			expr, err := value.eval(let_env)
+			if err != nil {
+				return nil, nil, errAtSpan(span, err)
+			}
+			let_env.slots[slots[i]].Store(&expr)
+		}
+		return body(let_env)
+	}
+}
+
+func (me *compiler) compileFn(form ExprList, span *SrcSpan) compiled {
+	fn, err := fnFrom(form[1:])
+	if err != nil {
+		return compiledErr(span, err)
+	}
+	fn.code.scope = me.scope
+	return func(env *Env) (*Env, Expr, error) {
+		it := *fn // so `it.code` is shared by all `it`s, compiled on the first call of any one of them
+		it.env = fnEnv(env)
+		if env.interp.Options.NoTco {
+			return nil, (ExprFunc)(it.Call), nil
+		}
+		return nil, &it, nil
+	}
+}
+
+func (me *compiler) compileTryCatch(form ExprList, span *SrcSpan) compiled {
+	catch, err := tryCatchClause(me.env.interp, form[1:])
+	if err != nil {
+		return compiledErr(span, err)
+	}
+	body := me.compile(form[1])
+	if catch == nil {
+		return body
+	}
+	for _, name := range catch[1 : len(catch)-1] {
+		me.scope.bind(name.(ExprIdent))
+	}
+	handler := me.compile(catch[len(catch)-1])
+	return func(env *Env) (*Env, Expr, error) {
+		expr, err := body.eval(env)
+		if isErrExit(err) {
+			return nil, nil, err
+		} else if err != nil {
+			tryCatchBind(env, catch, err)
+			return handler(env)
+		}
+		return nil, expr, nil
+	}
+}
+
+// isConstForm tells whether `expr` always evaluates to itself.
+func isConstForm(expr Expr) bool {
+	switch it := expr.(type) {
+	case ExprIdent, ExprVec, ExprHashMap:
+		return false
+	case ExprList:
+		return len(it) == 0
+	}
+	return true
+}
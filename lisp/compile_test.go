@@ -0,0 +1,59 @@
+package lisp
+
+import "testing"
+
+// TestMacroRedefinition checks that compiled `fn` bodies, which have all macro calls expanded, do not keep using the expansions of a
+// macro that has since been redefined (or rebound to a non-macro), both as compiled to Go closures and to bytecode.
+func TestMacroRedefinition(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		interp := NewInterpreter(Options{Bytecode: bytecode})
+		testEvalAll(t, interp, [][2]string{
+			{"(do (def twice (macro (x) ´(+ ~x ~x))) (def f (fn (n) (twice n))) (f 3))", "6"},
+			{"(do (set twice (macro (x) ´(* ~x ~x))) (f 3))", "9"},
+			{"(do (set twice (fn (x) (list x x))) (f 3))", "(3 3)"},
+		})
+	}
+}
+
+const srcBenchFib = `
+(def fib
+	(fn (n)
+		(if (< n 2)
+			n
+			(+ (fib (- n 1)) (fib (- n 2))))))`
+
+const srcBenchLoop = `
+(def loop
+	(fn (n acc)
+		(if (= n 0)
+			acc
+			(loop (- n 1) (if (and (> n 10) (or (< n 20) (= 0 (- n n)))) (+ acc n) acc)))))`
+
+// BenchmarkEval runs some calls-heavy and some macros-heavy code in the ways `fn` bodies can run: compiled to Go closures (by default),
+// compiled to bytecode (with `Options.Bytecode`), and interpreted (forced here via the `Coverage`, so it includes its recording).
+func BenchmarkEval(b *testing.B) {
+	for _, mode := range []string{"compiled", "bytecode", "interpreted"} {
+		interp := NewInterpreter(Options{Bytecode: mode == "bytecode"})
+		if mode == "interpreted" {
+			interp.Coverage.Start()
+		}
+		for _, src := range []string{srcBenchFib, srcBenchLoop} {
+			if _, err := interp.ReadAndEval("", src); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for _, it := range [][2]string{{"fib", "(fib 20)"}, {"loop", "(loop 10000 0)"}} {
+			expr, err := readExpr("", it[1])
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(it[0]+"/"+mode, func(b *testing.B) {
+				for b.Loop() {
+					if _, err := interp.Eval(expr); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
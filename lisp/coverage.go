@@ -0,0 +1,501 @@
+package lisp
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Coverage records, while on, which forms (and which branches of `if`s) ran in the source files read by `Load` (and so by
+// `LoadFile` and `loadFile`), for `WriteText`, `WriteHTML` and `WriteJSON`. only lists count as forms: any atoms (such as
+// identifiers and numbers) count only as part of the list they are in. while it is on, all evaluation is interpreted in
+// `evalAndApply` (rather than compiled) so it sees every form.
+type Coverage struct {
+	interp *Interpreter
+	on     atomic.Bool // so that the common not-covering case needs no locking
+	mutex  sync.Mutex  // for all the below
+	files  []string
+	forms  map[string][]Expr // the top-level forms of each of the `files`, as last read
+	hits   map[*SrcSpan]int
+	ifs    map[*Expr]*SrcSpan   // for `branch`: the `if` forms that ran, by the address of their condition
+	branch map[*SrcSpan]*[2]int // how often each `if` form that ran took its then branch and its else branch
+}
+
+// covFile is the coverage of one source file, with its forms and `if` forms in source order.
+type covFile struct {
+	path  string
+	forms []covForm
+	ifs   []covIf
+}
+
+type covForm struct {
+	span *SrcSpan
+	hits int
+}
+
+type covIf struct {
+	span     *SrcSpan
+	branches [2]int // then and else
+}
+
+// CoverageCounts are how many forms (or `if` branches) ran, out of all of them.
+type CoverageCounts struct {
+	Covered int     `json:"covered"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"` // 100 if `Total` is 0
+}
+
+// Start begins (or continues) recording coverage.
+func (me *Coverage) Start() {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if me.forms == nil {
+		me.forms, me.hits, me.ifs, me.branch = map[string][]Expr{}, map[*SrcSpan]int{}, map[*Expr]*SrcSpan{}, map[*SrcSpan]*[2]int{}
+	}
+	me.on.Store(true)
+}
+
+// Stop pauses recording coverage.
+func (me *Coverage) Stop() {
+	me.on.Store(false)
+}
+
+// read is called by `Load` for every top-level form it reads from `srcFilePath`, before evaluating it.
+func (me *Coverage) read(srcFilePath string, form Expr) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	forms, known := me.forms[srcFilePath]
+	if !known {
+		me.files = append(me.files, srcFilePath)
+	} else if span := srcSpanOf(form); (span != nil) && (len(forms) > 0) {
+		if last := srcSpanOf(forms[len(forms)-1]); (last != nil) && ((span.Line < last.Line) || ((span.Line == last.Line) && (span.Col <= last.Col))) {
+			forms = nil // the file is being loaded again
+		}
+	}
+	me.forms[srcFilePath] = append(forms, form)
+}
+
+// ran is called by `evalAndApply` before evaluating each non-empty list `form`.
+func (me *Coverage) ran(form ExprList) {
+	span := srcSpanOf(form)
+	if span == nil {
+		return
+	}
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.hits[span]++
+	if ident, _ := form[0].(ExprIdent); (ident == "if") && (len(form) > 1) {
+		me.ifs[&form[1]] = span
+	}
+}
+
+// branchTaken is called by `stdIf` with its `args` once it knows which branch it takes.
+func (me *Coverage) branchTaken(args []Expr, isThen bool) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	span := me.ifs[&args[0]]
+	if span == nil {
+		return
+	}
+	counts := me.branch[span]
+	if counts == nil {
+		counts = &[2]int{}
+		me.branch[span] = counts
+	}
+	if isThen {
+		counts[0]++
+	} else {
+		counts[1]++
+	}
+}
+
+// report returns the coverage of all files read so far. (for a file read more than once, of all runs of its forms.)
+func (me *Coverage) report() []covFile {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	type pos struct {
+		file      string
+		line, col int
+	}
+	hits, branches := map[pos]int{}, map[pos][2]int{}
+	for span, num_hits := range me.hits {
+		hits[pos{span.File, span.Line, span.Col}] += num_hits
+	}
+	for span, counts := range me.branch {
+		key := pos{span.File, span.Line, span.Col}
+		sum := branches[key]
+		branches[key] = [2]int{sum[0] + counts[0], sum[1] + counts[1]}
+	}
+
+	ret := make([]covFile, 0, len(me.files))
+	for _, file_path := range me.files {
+		file := covFile{path: file_path}
+		for _, form := range me.forms[file_path] {
+			me.walk(form, false, func(span *SrcSpan, isIf bool) {
+				key := pos{span.File, span.Line, span.Col}
+				file.forms = append(file.forms, covForm{span: span, hits: hits[key]})
+				if isIf {
+					file.ifs = append(file.ifs, covIf{span: span, branches: branches[key]})
+				}
+			})
+		}
+		ret = append(ret, file)
+	}
+	return ret
+}
+
+// walk calls `visit` for every form in `expr` that would get evaluated, as far as can be told without expanding macros:
+// in the args of macro calls, those are taken to be only the lists that start with the name of a special form, a
+// top-level `def` or a `fn`. (as those args are commonly just forms spliced into the expansion.)
+func (me *Coverage) walk(expr Expr, inMacroArgs bool, visit func(span *SrcSpan, isIf bool)) {
+	walk_all := func(exprs []Expr, inMacroArgs bool) {
+		for _, it := range exprs {
+			me.walk(it, inMacroArgs, visit)
+		}
+	}
+	switch it := expr.(type) {
+	case ExprVec:
+		walk_all(it, inMacroArgs)
+	case ExprHashMap:
+		for _, key := range slices.Sorted(maps.Keys(it)) {
+			me.walk(it[key], inMacroArgs, visit)
+		}
+	case ExprList:
+		if len(it) == 0 {
+			return
+		} else if inMacroArgs && !me.isCall(it) {
+			walk_all(it, true)
+			return
+		}
+		head, _ := it[0].(ExprIdent)
+		if span := srcSpanOf(it); span != nil {
+			visit(span, head == "if")
+		}
+		args := it[1:]
+		switch head {
+		case exprIdentQuote, "macroExpand":
+		case exprIdentQuasiQuote:
+			me.walkUnquoted(args, visit)
+		case "fn", exprIdentMacro:
+			if len(args) > 1 {
+				walk_all(args[1:], false)
+			}
+		case "let":
+			if len(args) > 0 {
+				bindings, _ := checkIsSeq(args[0])
+				for _, binding := range bindings {
+					if pair, _ := checkIsSeq(binding); len(pair) == 2 {
+						me.walk(pair[1], false, visit)
+					}
+				}
+				walk_all(args[1:], false)
+			}
+		case "def", "set":
+			if len(args) == 2 {
+				if macro, is_macro, _ := isListStartingWithIdent(args[1], exprIdentMacro, -1); is_macro && (len(macro) > 2) {
+					walk_all(macro[2:], false) // `defOrSet` makes `macro`s without evaluating the form
+				} else {
+					me.walk(args[1], false, visit)
+				}
+			}
+		case "try":
+			if len(args) > 0 {
+				me.walk(args[0], false, visit)
+			}
+			if len(args) > 1 {
+				if catch, _ := args[1].(ExprList); len(catch) > 1 {
+					me.walk(catch[len(catch)-1], false, visit)
+				}
+			}
+		case "select":
+			for _, arg := range args {
+				if clause, _ := arg.(ExprList); len(clause) > 1 {
+					if kind, _ := clause[0].(ExprIdent); kind == "default" {
+						walk_all(clause[1:], false)
+					} else {
+						me.walk(clause[1], false, visit)
+						if (kind == "chanSend") && (len(clause) > 2) {
+							me.walk(clause[2], false, visit)
+						}
+						if len(clause) > 3 {
+							walk_all(clause[3:], false)
+						}
+					}
+				}
+			}
+		default:
+			if fn, _ := me.interp.Env.find(head).(*ExprFn); (head != "") && (fn != nil) && fn.isMacro {
+				walk_all(args, true)
+			} else {
+				walk_all(it, false)
+			}
+		}
+	}
+}
+
+// walkUnquoted walks the `unquote`d and `spliceUnquote`d forms in the `quasiQuote`d `exprs`.
+func (me *Coverage) walkUnquoted(exprs []Expr, visit func(span *SrcSpan, isIf bool)) {
+	for _, expr := range exprs {
+		switch it := expr.(type) {
+		case ExprList:
+			if len(it) == 0 {
+				continue
+			} else if head, _ := it[0].(ExprIdent); ((head == exprIdentUnquote) || (head == exprIdentSpliceUnquote)) && (len(it) == 2) {
+				me.walk(it[1], false, visit)
+			} else {
+				me.walkUnquoted(it, visit)
+			}
+		case ExprVec:
+			me.walkUnquoted(it, visit)
+		}
+	}
+}
+
+// isCall returns whether `list` starts with the name of a special form or of a top-level `def`, or with a `fn` form.
+func (me *Coverage) isCall(list ExprList) bool {
+	switch head := list[0].(type) {
+	case ExprIdent:
+		_, is_special := me.interp.specialForms[head]
+		return is_special || me.interp.Env.hasOwn(head)
+	case ExprList:
+		_, is_fn, _ := isListStartingWithIdent(head, "fn", -1)
+		return is_fn
+	}
+	return false
+}
+
+func (me *covFile) counts() (forms CoverageCounts, branches CoverageCounts) {
+	for _, form := range me.forms {
+		forms.Total++
+		if form.hits > 0 {
+			forms.Covered++
+		}
+	}
+	for _, it := range me.ifs {
+		for _, count := range it.branches {
+			branches.Total++
+			if count > 0 {
+				branches.Covered++
+			}
+		}
+	}
+	return forms.withPercent(), branches.withPercent()
+}
+
+func (me CoverageCounts) withPercent() CoverageCounts {
+	me.Percent = 100
+	if me.Total > 0 {
+		me.Percent = 100 * float64(me.Covered) / float64(me.Total)
+	}
+	return me
+}
+
+// notRun returns the lines that forms not run begin on, as in "3, 7-9".
+func (me *covFile) notRun() string {
+	var lines []int
+	for _, form := range me.forms {
+		if form.hits == 0 {
+			lines = append(lines, form.span.Line)
+		}
+	}
+	slices.Sort(lines)
+	lines = slices.Compact(lines)
+	var ret []string
+	for i := 0; i < len(lines); {
+		j := i
+		for (j+1 < len(lines)) && (lines[j+1] == lines[j]+1) {
+			j++
+		}
+		if ret = append(ret, strconv.Itoa(lines[i])); j > i {
+			ret[len(ret)-1] += "-" + strconv.Itoa(lines[j])
+		}
+		i = j + 1
+	}
+	return strings.Join(ret, ", ")
+}
+
+// Counts returns how many forms and how many `if` branches ran, of all in the source files read so far.
+func (me *Coverage) Counts() (forms CoverageCounts, branches CoverageCounts) {
+	for _, file := range me.report() {
+		file_forms, file_branches := file.counts()
+		forms.Covered, forms.Total = forms.Covered+file_forms.Covered, forms.Total+file_forms.Total
+		branches.Covered, branches.Total = branches.Covered+file_branches.Covered, branches.Total+file_branches.Total
+	}
+	return forms.withPercent(), branches.withPercent()
+}
+
+// CheckMin returns an error if less than `minPercent` of all forms in the source files read so far ran.
+func (me *Coverage) CheckMin(minPercent float64) error {
+	if forms, _ := me.Counts(); forms.Percent < minPercent {
+		return fmt.Errorf("coverage of forms %.1f%% is below the minimum of %.1f%%", forms.Percent, minPercent)
+	}
+	return nil
+}
+
+// WriteText writes a summary: per source file and in total, the percentages of forms and of `if` branches that ran.
+func (me *Coverage) WriteText(w io.Writer) error {
+	files := me.report()
+	var buf strings.Builder
+	line := func(forms CoverageCounts, branches CoverageCounts, what string) {
+		fmt.Fprintf(&buf, "%6.1f%% %11s %6.1f%% %11s  %s\n", forms.Percent, strconv.Itoa(forms.Covered)+"/"+strconv.Itoa(forms.Total),
+			branches.Percent, strconv.Itoa(branches.Covered)+"/"+strconv.Itoa(branches.Total), what)
+	}
+	fmt.Fprintf(&buf, "%19s %19s  %s\n", "forms", "if branches", "file")
+	for _, file := range files {
+		forms, branches := file.counts()
+		what := file.path
+		if not_run := file.notRun(); not_run != "" {
+			what += " (not run: lines " + not_run + ")"
+		}
+		line(forms, branches, what)
+	}
+	forms, branches := me.Counts()
+	line(forms, branches, "total")
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// WriteJSON writes the `Counts` (as `forms` and `branches`) and, for each source file, its own and the `line:col`
+// locations of the forms not run (`notRun`) and of the `if` forms with branches not taken (`branchesNotTaken`, each with `then` or `else`).
+func (me *Coverage) WriteJSON(w io.Writer) error {
+	type fileJSON struct {
+		File             string         `json:"file"`
+		Forms            CoverageCounts `json:"forms"`
+		Branches         CoverageCounts `json:"branches"`
+		NotRun           []string       `json:"notRun"`
+		BranchesNotTaken []string       `json:"branchesNotTaken"`
+	}
+	var out struct {
+		Forms    CoverageCounts `json:"forms"`
+		Branches CoverageCounts `json:"branches"`
+		Files    []fileJSON     `json:"files"`
+	}
+	out.Forms, out.Branches = me.Counts()
+	out.Files = []fileJSON{}
+	for _, file := range me.report() {
+		file_json := fileJSON{File: file.path, NotRun: []string{}, BranchesNotTaken: []string{}}
+		file_json.Forms, file_json.Branches = file.counts()
+		for _, form := range file.forms {
+			if form.hits == 0 {
+				file_json.NotRun = append(file_json.NotRun, strconv.Itoa(form.span.Line)+":"+strconv.Itoa(form.span.Col))
+			}
+		}
+		for _, it := range file.ifs {
+			for i, count := range it.branches {
+				if count == 0 {
+					file_json.BranchesNotTaken = append(file_json.BranchesNotTaken, strconv.Itoa(it.span.Line)+":"+strconv.Itoa(it.span.Col)+" "+[2]string{"then", "else"}[i])
+				}
+			}
+		}
+		out.Files = append(out.Files, file_json)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteHTML writes a page with the source of each source file (if still readable), where the forms not run are
+// marked, and so are the `if`s with a branch not taken (with how often each was, on hovering their `if`).
+func (me *Coverage) WriteHTML(w io.Writer) error {
+	files := me.report()
+	var buf strings.Builder
+	buf.WriteString(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>go-lisp coverage</title><style>
+body { font-family: sans-serif; }
+pre { line-height: 1.35; }
+.ln { display: inline-block; width: 4em; color: #999; user-select: none; }
+.ln.run { background: #dfd; } .ln.part { background: #ffc; } .ln.miss { background: #fdd; }
+.miss { background: #fbb; } .branch { background: #fd6; cursor: help; }
+</style></head><body>
+`)
+	forms, branches := me.Counts()
+	fmt.Fprintf(&buf, "<h1>coverage: forms %.1f%% (%d/%d), if branches %.1f%% (%d/%d)</h1>\n",
+		forms.Percent, forms.Covered, forms.Total, branches.Percent, branches.Covered, branches.Total)
+	for _, file := range files {
+		forms, branches := file.counts()
+		fmt.Fprintf(&buf, "<h2>%s: forms %.1f%% (%d/%d), if branches %.1f%% (%d/%d)</h2>\n", html.EscapeString(file.path),
+			forms.Percent, forms.Covered, forms.Total, branches.Percent, branches.Covered, branches.Total)
+		src, err := os.ReadFile(file.path)
+		if err != nil {
+			buf.WriteString("<p>(source not available)</p>\n")
+			continue
+		}
+		buf.WriteString("<pre>")
+		file.writeHTML(&buf, string(src))
+		buf.WriteString("</pre>\n")
+	}
+	buf.WriteString("</body></html>\n")
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// writeHTML writes `src` (the source of `me`) line by line with line numbers, marking the outermost forms not run and the
+// `if`s of the `if` forms run with a branch not taken. the marks are closed at the end of each line, and reopened on the next.
+func (me *covFile) writeHTML(buf *strings.Builder, src string) {
+	type mark struct {
+		from, until [2]int // line and col, `until` being exclusive
+		class       string
+		title       string
+	}
+	var marks []mark
+	var miss_until [2]int // of the last not-run form marked, to not mark the ones within it
+	cmp_pos := func(a [2]int, b [2]int) int { return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1])) }
+	lines_status := map[int]int{} // per line: 1 if all forms beginning on it ran, 2 if none did, 3 if some did
+	for _, form := range me.forms {
+		from, until := [2]int{form.span.Line, form.span.Col}, [2]int{form.span.LineEnd, form.span.ColEnd}
+		if form.hits > 0 {
+			lines_status[from[0]] |= 1
+		} else if lines_status[from[0]] |= 2; cmp_pos(from, miss_until) >= 0 {
+			marks, miss_until = append(marks, mark{from: from, until: until, class: "miss", title: "not run"}), until
+		}
+	}
+	for _, it := range me.ifs {
+		if (it.branches[0] == 0) == (it.branches[1] == 0) || (len(it.span.Items) == 0) { // both or neither taken
+			continue
+		}
+		head := it.span.Items[0]
+		marks = append(marks, mark{from: [2]int{head.Line, head.Col}, until: [2]int{head.LineEnd, head.ColEnd}, class: "branch",
+			title: "then taken " + strconv.Itoa(it.branches[0]) + "x, else taken " + strconv.Itoa(it.branches[1]) + "x"})
+	}
+	slices.SortStableFunc(marks, func(a mark, b mark) int { return cmp_pos(a.from, b.from) })
+
+	var open []mark // nested, outermost first
+	for line_idx, line := range strings.Split(strings.TrimSuffix(src, "\n"), "\n") {
+		line_num := line_idx + 1
+		status := [4]string{"", "run", "miss", "part"}[lines_status[line_num]]
+		fmt.Fprintf(buf, `<span class="ln %s">%4d</span> `, status, line_num)
+		for _, it := range open {
+			fmt.Fprintf(buf, `<span class="%s" title="%s">`, it.class, html.EscapeString(it.title))
+		}
+		col := 1
+		for _, r := range strings.TrimRight(line, "\r") {
+			pos := [2]int{line_num, col}
+			for (len(open) > 0) && (cmp_pos(pos, open[len(open)-1].until) >= 0) {
+				buf.WriteString("</span>")
+				open = open[:len(open)-1]
+			}
+			for (len(marks) > 0) && (cmp_pos(pos, marks[0].from) >= 0) {
+				fmt.Fprintf(buf, `<span class="%s" title="%s">`, marks[0].class, html.EscapeString(marks[0].title))
+				open, marks = append(open, marks[0]), marks[1:]
+			}
+			buf.WriteString(html.EscapeString(string(r)))
+			col++
+		}
+		for range open {
+			buf.WriteString("</span>")
+		}
+		for (len(open) > 0) && (cmp_pos([2]int{line_num, col}, open[len(open)-1].until) >= 0) {
+			open = open[:len(open)-1]
+		}
+		buf.WriteByte('\n')
+	}
+}
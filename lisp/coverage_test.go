@@ -0,0 +1,110 @@
+package lisp
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const srcTestCoverage = `(def sign (fn (n)
+  (if (< n 0)
+    (str "neg")
+    (str "pos"))))
+(sign 1)
+(sign 2)
+(def unused (fn () (str "never")))
+`
+
+// testCoverage loads `srcTestCoverage` as "cov.lisp" while recording coverage.
+func testCoverage(t *testing.T, bytecode bool) *Interpreter {
+	interp := NewInterpreter(Options{Bytecode: bytecode})
+	interp.Coverage.Start()
+	if err := interp.Load("cov.lisp", strings.NewReader(srcTestCoverage)); err != nil {
+		t.Fatal(err)
+	}
+	interp.Coverage.Stop()
+	return interp
+}
+
+func TestCoverageHits(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		interp := testCoverage(t, bytecode)
+		files := interp.Coverage.report()
+		if (len(files) != 1) || (files[0].path != "cov.lisp") {
+			t.Fatalf("bytecode=%v: expected only cov.lisp, got %v", bytecode, files)
+		}
+		var hits, ifs []string
+		for _, form := range files[0].forms {
+			hits = append(hits, strconv.Itoa(form.span.Line)+":"+strconv.Itoa(form.span.Col)+"="+strconv.Itoa(form.hits))
+		}
+		for _, it := range files[0].ifs {
+			ifs = append(ifs, strconv.Itoa(it.span.Line)+":"+strconv.Itoa(it.span.Col)+"="+strconv.Itoa(it.branches[0])+"/"+strconv.Itoa(it.branches[1]))
+		}
+		if expected := "1:1=1 1:11=1 2:3=2 2:7=2 3:5=0 4:5=2 5:1=1 6:1=1 7:1=1 7:13=1 7:20=0"; strings.Join(hits, " ") != expected {
+			t.Errorf("bytecode=%v: expected form hits\n%s\ngot\n%s", bytecode, expected, strings.Join(hits, " "))
+		}
+		if expected := "2:3=0/2"; strings.Join(ifs, " ") != expected {
+			t.Errorf("bytecode=%v: expected if branch hits %s, got %s", bytecode, expected, strings.Join(ifs, " "))
+		}
+		forms, branches := interp.Coverage.Counts()
+		if (forms.Covered != 9) || (forms.Total != 11) || (branches.Covered != 1) || (branches.Total != 2) || (branches.Percent != 50) {
+			t.Errorf("bytecode=%v: unexpected counts %+v and %+v", bytecode, forms, branches)
+		}
+	}
+}
+
+func TestCoverageWriteJSON(t *testing.T) {
+	var buf strings.Builder
+	if err := testCoverage(t, false).Coverage.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var report map[string]any
+	if err := json.Unmarshal([]byte(buf.String()), &report); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"forms":    map[string]any{"covered": 9.0, "total": 11.0, "percent": 100 * 9.0 / 11.0},
+		"branches": map[string]any{"covered": 1.0, "total": 2.0, "percent": 50.0},
+		"files": []any{map[string]any{
+			"file":             "cov.lisp",
+			"forms":            map[string]any{"covered": 9.0, "total": 11.0, "percent": 100 * 9.0 / 11.0},
+			"branches":         map[string]any{"covered": 1.0, "total": 2.0, "percent": 50.0},
+			"notRun":           []any{"3:5", "7:20"},
+			"branchesNotTaken": []any{"2:3 then"},
+		}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected\n%v\ngot\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := NewInterpreter(Options{}).Coverage.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	} else if expected := `"files": []`; !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %s without any files read, got\n%s", expected, buf.String())
+	}
+}
+
+func TestCoverageCheckMin(t *testing.T) {
+	interp := testCoverage(t, false) // 9 of 11 forms ran
+	for min_percent, expected := range map[float64]string{
+		0:     "",
+		80:    "",
+		81.8:  "",
+		81.82: "coverage of forms 81.8% is below the minimum of 81.8%",
+		90:    "coverage of forms 81.8% is below the minimum of 90.0%",
+		100:   "coverage of forms 81.8% is below the minimum of 100.0%",
+	} {
+		err := interp.Coverage.CheckMin(min_percent)
+		if (expected == "") && (err != nil) {
+			t.Errorf("min %v: %v", min_percent, err)
+		} else if (expected != "") && ((err == nil) || (err.Error() != expected)) {
+			t.Errorf("min %v: expected %q, got %v", min_percent, expected, err)
+		}
+	}
+	if err := NewInterpreter(Options{}).Coverage.CheckMin(100); err != nil {
+		t.Errorf("without any forms read: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+package lisp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// returned by evaluations aborted by the `quit` command of the `Debugger`
+var errDebugQuit = errors.New("quit in the debugger")
+
+const debuggerHelp = `c, continue       run on until the next breakpoint
+s, step           step into: pause at the very next form
+n, next           step over: pause at the next form that is not nested in this one (or in any call it makes)
+o, out            step out: pause at the next form after the current call returns
+b, break SPEC     add a breakpoint: a function name, or a source line as file:line or just line (in the current file)
+d, delete [SPEC]  remove a breakpoint, or all of them
+l, list           list the breakpoints
+e, env            show the Env chain: the locals of each frame, innermost first
+p, print EXPR     evaluate EXPR in the paused Env and print the result (also for any input starting with a paren)
+w, where          show again where it is paused, with its source line
+q, quit           abort the evaluation
+(an empty input repeats the last command)`
+
+type debugStep int
+
+const (
+	debugStepNone debugStep = iota
+	debugStepInto
+	debugStepOver
+	debugStepOut
+)
+
+// Debugger pauses evaluations at breakpoints and after steps, to then take commands (see `debuggerHelp`) from `In`.
+// while it is on, all evaluation is interpreted in `evalAndApply` (rather than compiled) so it sees every form.
+// with several goroutines evaluating, the first to pause holds up all others at their next form until it continues,
+// and stepping over and out goes by a nesting depth shared by all of them.
+type Debugger struct {
+	In  io.Reader // commands are read from here line by line, via the `LineEditor` if that is `os.Stdin` on an interactive terminal
+	Out io.Writer
+
+	on      atomic.Bool  // so that the common not-debugging case needs no locking
+	nesting atomic.Int32 // see `debugLevel`
+	mutex   sync.Mutex   // for all the below, held while paused
+	fns     map[string]bool
+	lines   map[int][]string // the files of line breakpoints, by line
+	step    debugStep
+	level   int    // for `debugStepOver` and `debugStepOut`: that of the form or call last paused at
+	prevAt  string // the `file:line` of the last form seen, to not pause again at the forms nested in it on the same line
+	file    string // of the last form paused at, for `break`s given just a line
+	lastCmd string
+	stdin   *bufio.Reader // over `In` if no `LineEditor`
+	stdinOf io.Reader     // what `stdin` reads from
+}
+
+// debugPause is what the `Debugger` paused at.
+type debugPause struct {
+	env    *Env
+	form   Expr
+	span   *SrcSpan
+	fnName string // the `*ExprFn` being evaluated the body of, if any
+	reason string
+}
+
+// Start turns `me` on for all evaluations from now on until `Stop`, pausing at the very next form if `paused`.
+// it returns `false` if `me` already was on.
+func (me *Debugger) Start(paused bool) bool {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if paused {
+		me.step = debugStepInto
+	}
+	return !me.on.Swap(true)
+}
+
+// Stop turns `me` off, so evaluations run on as usual, compiled again (though keeping the breakpoints for the next `Start`).
+func (me *Debugger) Stop() {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.on.Store(false)
+	me.step = debugStepNone
+}
+
+// Break adds (or, if not `on`, removes) the breakpoints in `specs`: function names or source lines as `file:line`,
+// where `file` matches any source file path of that name or ending in it.
+func (me *Debugger) Break(on bool, specs ...string) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.breakSet(on, specs...)
+}
+
+func (me *Debugger) breakSet(on bool, specs ...string) {
+	for _, spec := range specs {
+		file, line := debugBreakLine(spec)
+		switch {
+		case on && (line > 0):
+			if !slices.Contains(me.lines[line], file) {
+				me.lines[line] = append(me.lines[line], file)
+			}
+		case on:
+			me.fns[spec] = true
+		case line > 0:
+			if me.lines[line] = slices.DeleteFunc(me.lines[line], func(it string) bool { return it == file }); len(me.lines[line]) == 0 {
+				delete(me.lines, line)
+			}
+		default:
+			delete(me.fns, spec)
+		}
+	}
+}
+
+// debugBreakLine returns the file and line of `spec` if it is a line breakpoint, else `0`.
+func debugBreakLine(spec string) (string, int) {
+	if idx := strings.LastIndexByte(spec, ':'); idx > 0 {
+		if line, err := strconv.Atoi(spec[idx+1:]); (err == nil) && (line > 0) {
+			return spec[:idx], line
+		}
+	}
+	return "", 0
+}
+
+// breaks returns all breakpoints, sorted.
+func (me *Debugger) breaks() []string {
+	ret := slices.Collect(maps.Keys(me.fns))
+	for line, files := range me.lines {
+		for _, file := range files {
+			ret = append(ret, file+":"+strconv.Itoa(line))
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// debugLevel orders the forms and calls seen by the `Debugger` by nesting, for stepping over and out of them: `nesting` counts
+// the `evalAndApply` calls in progress, and the forms in the body of an `*ExprFn` are one level deeper than its call, which
+// happens in the same `evalAndApply` call. (so stepping over a tail call steps into it, as it replaces its caller.)
+func debugLevel(nesting int, inFn bool) int {
+	if inFn {
+		return (2 * nesting) + 1
+	}
+	return 2 * nesting
+}
+
+// atForm is called by `evalAndApply` before evaluating each non-empty list `form`, to pause there if stepping or at a line breakpoint.
+func (me *Debugger) atForm(env *Env, form ExprList, nesting int, frame *StackFrame) error {
+	if !me.on.Load() {
+		return nil
+	}
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	level, span := debugLevel(nesting, frame != nil), srcSpanOf(form)
+	var at string // the `file:line` of `form`
+	if span != nil {
+		at = span.File + ":" + strconv.Itoa(span.Line)
+	}
+	var reason string
+	switch {
+	case me.step == debugStepInto, (me.step == debugStepOver) && (level <= me.level), (me.step == debugStepOut) && (level < me.level):
+		reason = "step"
+	case (span != nil) && (at != me.prevAt):
+		for _, file := range me.lines[span.Line] {
+			if (file == span.File) || strings.HasSuffix(span.File, "/"+file) {
+				reason = "breakpoint " + file + ":" + strconv.Itoa(span.Line)
+				break
+			}
+		}
+	}
+	if span != nil {
+		me.prevAt = at
+	}
+	if reason == "" {
+		return nil
+	} else if span != nil {
+		me.file = span.File
+	}
+	var fn_name string
+	if frame != nil {
+		fn_name = frame.Name
+	}
+	return me.pause(level, &debugPause{env: env, form: form, span: span, fnName: fn_name, reason: reason})
+}
+
+// atCall is called by `evalAndApply` before calling a builtin `ExprFunc` (with `frame` being `nil`), or right after entering an `*ExprFn`
+// (with `env` being its own and `frame` the new one), to pause there if `name` has a breakpoint. `span` is that of the call, if known.
+func (me *Debugger) atCall(env *Env, name string, args []Expr, span *SrcSpan, level int, frame *StackFrame) error {
+	if !me.on.Load() {
+		return nil
+	}
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	var fn_name string
+	if frame != nil {
+		fn_name, me.prevAt = frame.Name, "" // so a line breakpoint in the body pauses again on every call, even if on the line of the call
+	}
+	if (name == "") || !me.fns[name] {
+		return nil
+	}
+	return me.pause(level, &debugPause{env: env, form: append(ExprList{ExprIdent(name)}, args...), span: span, fnName: fn_name, reason: "breakpoint " + name})
+}
+
+// pause takes commands until one continues the evaluation. `level` is that of `at`, for the next step over or out.
+func (me *Debugger) pause(level int, at *debugPause) error {
+	me.step, me.level = debugStepNone, level
+	me.where(at, false)
+	for {
+		line, err := me.readLine(at.env)
+		if err == io.EOF { // no more commands, so run on as if not debugging
+			me.on.Store(false)
+			return nil
+		} else if err == errInputCanceled {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			line = me.lastCmd
+		}
+		me.lastCmd = line
+		cmd, arg, _ := strings.Cut(line, " ")
+		if arg = strings.TrimSpace(arg); strings.HasPrefix(cmd, "(") {
+			cmd, arg = "p", line
+		}
+		switch cmd {
+		case "":
+		case "c", "continue":
+			return nil
+		case "s", "step":
+			me.step = debugStepInto
+			return nil
+		case "n", "next":
+			me.step = debugStepOver
+			return nil
+		case "o", "out":
+			me.step = debugStepOut
+			return nil
+		case "b", "break", "d", "delete":
+			is_break := (cmd == "b") || (cmd == "break")
+			if (arg == "") && is_break {
+				fmt.Fprintln(me.Out, "missing: function name or source line")
+				continue
+			} else if arg == "" {
+				clear(me.fns)
+				clear(me.lines)
+				continue
+			} else if _, err := strconv.Atoi(arg); (err == nil) && (me.file != "") {
+				arg = me.file + ":" + arg
+			}
+			me.breakSet(is_break, arg)
+		case "l", "list":
+			for _, spec := range me.breaks() {
+				fmt.Fprintln(me.Out, spec)
+			}
+		case "e", "env":
+			me.printEnv(at.env)
+		case "p", "print":
+			me.print(at.env, arg)
+		case "w", "where":
+			me.where(at, true)
+		case "q", "quit":
+			return errDebugQuit
+		case "h", "help", "?":
+			fmt.Fprintln(me.Out, debuggerHelp)
+		default:
+			fmt.Fprintln(me.Out, "unknown command `"+cmd+"`, try `help`")
+		}
+	}
+}
+
+// readFrom makes `me` read its commands via `buffered` (instead of a `bufio.Reader` of its own) while `In` is `src`, which `buffered` reads from.
+func (me *Debugger) readFrom(src io.Reader, buffered *bufio.Reader) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.stdin, me.stdinOf = buffered, src
+}
+
+// readLine reads the next command, completing identifiers (if on the interactive terminal) from those in scope in `env`.
+func (me *Debugger) readLine(env *Env) (string, error) {
+	const prompt = "debug> "
+	if me.In == io.Reader(os.Stdin) {
+		if line_editor := stdinLineEditor(); line_editor != nil {
+			env_repl := line_editor.Env
+			defer func() { line_editor.Env = env_repl }()
+			line_editor.Env = env
+			return line_editor.ReadLine(prompt)
+		}
+	}
+	if (me.stdin == nil) || (me.stdinOf != me.In) {
+		me.stdin, me.stdinOf = bufio.NewReader(me.In), me.In
+	}
+	fmt.Fprint(me.Out, prompt)
+	line, err := me.stdin.ReadString('\n')
+	if (err == io.EOF) && (line != "") {
+		err = nil
+	}
+	return line, err
+}
+
+// where prints what `at` is, and if `withSrc` also its source line (if in a file that is still there).
+func (me *Debugger) where(at *debugPause, withSrc bool) {
+	loc := "<unknown location>"
+	if at.span != nil {
+		loc = at.span.String()
+	}
+	if at.fnName != "" {
+		loc += " in `" + at.fnName + "`"
+	}
+	fmt.Fprintln(me.Out, "paused at "+loc+" ("+at.reason+"):")
+	fmt.Fprintln(me.Out, "    "+debugAbbrev(str(true, at.form)))
+	if withSrc && (at.span != nil) {
+		if src, err := os.ReadFile(at.span.File); err == nil {
+			if lines := strings.Split(string(src), "\n"); at.span.Line <= len(lines) {
+				fmt.Fprintln(me.Out, strconv.Itoa(at.span.Line)+" |  "+strings.TrimRight(lines[at.span.Line-1], "\r"))
+			}
+		}
+	}
+}
+
+// printEnv prints the locals of each `Env` from `env` up to (but not into) the root env.
+func (me *Debugger) printEnv(env *Env) {
+	frame := 0
+	for ; env != nil; env = env.Parent {
+		if env.isTransparent() {
+			continue
+		} else if env.Parent == nil {
+			fmt.Fprintf(me.Out, "#%d  the root env: %d names\n", frame, len(env.Map))
+			break
+		}
+		var locals []string
+		env.mutex.RLock()
+		for i, name := range env.names {
+			if value := env.slots[i].Load(); value != nil { // else, not yet bound by its `let`
+				locals = append(locals, str(true, name)+" = "+debugAbbrev(str(true, *value)))
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(env.Map)) {
+			locals = append(locals, string(name)+" = "+debugAbbrev(str(true, env.Map[name])))
+		}
+		env.mutex.RUnlock()
+		if len(locals) == 0 {
+			locals = append(locals, "(nothing bound yet)")
+		}
+		fmt.Fprintf(me.Out, "#%d\n    %s\n", frame, strings.Join(locals, "\n    "))
+		frame++
+	}
+}
+
+// print evaluates `src` in `env` (undebugged) and prints the result.
+func (me *Debugger) print(env *Env, src string) {
+	expr, err := readExpr("<debug>", src)
+	if (err == nil) && (expr == nil) {
+		err = errors.New("missing: expression to evaluate")
+	}
+	if err == nil {
+		me.on.Store(false)
+		expr, err = evalAndApply(env, expr)
+		me.on.Store(true)
+	}
+	if err != nil {
+		fmt.Fprintln(me.Out, ErrStackTrace(err))
+	} else {
+		fmt.Fprintln(me.Out, str(true, expr))
+	}
+}
+
+func debugAbbrev(s string) string {
+	const maxLen = 160
+	if s = strings.ReplaceAll(s, "\n", " "); len(s) > maxLen {
+		return s[:maxLen-3] + "..."
+	}
+	return s
+}
+
+// stdDebug is the `(debug expr)` special form: it evaluates `expr` in the `Debugger`, pausing right at its start.
+func stdDebug(env *Env, args []Expr) (*Env, Expr, error) {
+	if err := checkArgsCount(1, 1, "`debug`", args); err != nil {
+		return nil, nil, err
+	}
+	if debugger := &env.interp.Debugger; debugger.Start(true) {
+		defer debugger.Stop()
+	}
+	expr, err := evalAndApply(env, args[0])
+	return nil, expr, err
+}
@@ -0,0 +1,151 @@
+package lisp
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const srcTestDebug = `(def add (fn (a b)
+  (let ((sum (+ a b)))
+    (* sum 2))))
+(def top (fn (n)
+  (add n 0)
+  (+ (add n 1) 100)))
+(def result (top 5))
+`
+
+// testDebugRootEnv matches what `printEnv` prints for the root env, whose count of names changes with every new builtin.
+var testDebugRootEnv = regexp.MustCompile(`the root env: \d+ names`)
+
+// testDebug loads `srcTestDebug` as "dbg.lisp" in the `Debugger`, with `breaks` and the `cmds` as its input,
+// and returns what it printed (with each `debug> ` prompt replaced by the command read), the `result` and the `Load` error.
+func testDebug(bytecode bool, breaks []string, cmds ...string) (string, string, error) {
+	interp := NewInterpreter(Options{Bytecode: bytecode})
+	var out strings.Builder
+	interp.Debugger.In, interp.Debugger.Out = strings.NewReader(strings.Join(cmds, "\n")+"\n"), &out
+	interp.Debugger.Break(true, breaks...)
+	interp.Debugger.Start(len(breaks) == 0)
+	err := interp.Load("dbg.lisp", strings.NewReader(srcTestDebug))
+	interp.Debugger.Stop()
+
+	var outputs []string
+	for i, output := range strings.Split(out.String(), "debug> ") {
+		if i > 0 {
+			if i <= len(cmds) {
+				output = "> " + cmds[i-1] + "\n" + output
+			} else {
+				output = "> (end of input)\n" + output
+			}
+		}
+		outputs = append(outputs, output)
+	}
+	return testDebugRootEnv.ReplaceAllString(strings.Join(outputs, ""), "the root env"), testEval(interp, "result"), err
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		out, result, err := testDebug(bytecode, []string{"add", "dbg.lisp:3"},
+			"l", "e", "p (+ a b 1000)", "c",
+			"e", "(* sum 10)", "c",
+			"c",
+			"print sum", "p undefinedName", "continue")
+		if err != nil {
+			t.Fatal(err)
+		} else if result != "112" {
+			t.Errorf("bytecode=%v: expected result 112, got %s", bytecode, result)
+		}
+		if expected := `paused at dbg.lisp:5:3 in ` + "`add`" + ` (breakpoint add):
+    (add 5 0)
+> l
+add
+dbg.lisp:3
+> e
+#0
+    a = 5
+    b = 0
+#1  the root env
+> p (+ a b 1000)
+1005
+> c
+paused at dbg.lisp:3:5 in ` + "`add`" + ` (breakpoint dbg.lisp:3):
+    (* sum 2)
+> e
+#0
+    sum = 5
+#1
+    a = 5
+    b = 0
+#2  the root env
+> (* sum 10)
+50
+> c
+paused at dbg.lisp:6:6 in ` + "`add`" + ` (breakpoint add):
+    (add 5 1)
+> c
+paused at dbg.lisp:3:5 in ` + "`add`" + ` (breakpoint dbg.lisp:3):
+    (* sum 2)
+> print sum
+6
+> p undefinedName
+undefined: undefinedName
+> continue
+`; out != expected {
+			t.Errorf("bytecode=%v: expected\n%s\ngot\n%s", bytecode, expected, out)
+		}
+	}
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	out, result, err := testDebug(false, []string{"top"}, "n", "s", "s", "n", "n", "c")
+	if err != nil {
+		t.Fatal(err)
+	} else if result != "112" {
+		t.Errorf("expected result 112, got %s", result)
+	}
+	if expected := `paused at dbg.lisp:7:13 in ` + "`top`" + ` (breakpoint top):
+    (top 5)
+> n
+paused at <unknown location> in ` + "`top`" + ` (step):
+    (do (add n 0) (+ (add n 1) 100))
+> s
+paused at dbg.lisp:5:3 (step):
+    (add n 0)
+> s
+paused at dbg.lisp:2:3 in ` + "`add`" + ` (step):
+    (let ((sum (+ a b))) (* sum 2))
+> n
+paused at dbg.lisp:3:5 in ` + "`add`" + ` (step):
+    (* sum 2)
+> n
+paused at dbg.lisp:6:3 in ` + "`top`" + ` (step):
+    (+ (add n 1) 100)
+> c
+`; out != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+
+	out, _, _ = testDebug(false, nil, "s", "n")
+	if expected := `paused at dbg.lisp:1:1 (step):
+    (def add (fn (a b) (let ((sum (+ a b))) (* sum 2))))
+> s
+paused at dbg.lisp:1:10 (step):
+    (fn (a b) (let ((sum (+ a b))) (* sum 2)))
+> n
+paused at dbg.lisp:4:1 (step):
+    (def top (fn (n) (add n 0) (+ (add n 1) 100)))
+> (end of input)
+`; out != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	_, result, err := testDebug(false, []string{"dbg.lisp:3"}, "q")
+	if !errors.Is(err, errDebugQuit) {
+		t.Errorf("expected %v, got %v", errDebugQuit, err)
+	} else if result != "error: undefined: result" {
+		t.Errorf("expected result to be undefined, got %s", result)
+	}
+}
@@ -0,0 +1,158 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+type SpecialForm = func(*Env, []Expr) (*Env, Expr, error)
+
+// Env is safe for concurrent use via its methods. its `Map` may only be accessed directly while no other goroutines use it,
+// and for the root `Env` of an `Interpreter`, only to add new entries (because compiled code caches all others).
+// an `Env` with neither a `Map` nor `slots` is transparent: it only carries a `ctx` for evaluations in it, while all
+// `def`s and `set`s go to its `Parent`.
+type Env struct {
+	Parent *Env
+	Map    map[ExprIdent]Expr // for an `Env` with `slots`, `nil` until the first `def` or `set` of a name not in `names`
+
+	names  []Expr                 // for `fn` calls and compiled `let`s: the `ExprIdent`s of the `slots`, which never change
+	slots  []atomic.Pointer[Expr] // for `fn` calls and compiled `let`s, never `nil`: the values of the `names`, `nil` until bound
+	interp *Interpreter           // the same for an `Env` and all its ancestors
+	ctx    context.Context        // if `nil`, that of `Parent` (or `context.Background()`) applies
+	depth  int                    // if 0, that of `Parent` applies: only tracked for `Sandbox.MaxDepth`
+	prof   *profFrame             // for `fn` calls only, if recorded by the `Profiler`
+	mutex  sync.RWMutex
+}
+
+func newCtxEnv(parent *Env, ctx context.Context) *Env {
+	return &Env{Parent: parent, interp: parent.interp, ctx: ctx}
+}
+
+// context returns the `context.Context` that evaluations in `me` are to be canceled by.
+func (me *Env) context() context.Context {
+	for env := me; env != nil; env = env.Parent {
+		if env.ctx != nil {
+			return env.ctx
+		}
+	}
+	return context.Background()
+}
+
+// callDepth returns the nesting depth of non-tail calls that evaluations in `me` happen at.
+func (me *Env) callDepth() int {
+	for env := me; env != nil; env = env.Parent {
+		if env.depth != 0 {
+			return env.depth
+		}
+	}
+	return 0
+}
+
+func newEnv(parent *Env, names []Expr, exprs []Expr) *Env {
+	if len(names) != len(exprs) {
+		panic(fmt.Sprintf("NEWLY INTRO'd BUG in go-lisp codebase: %d vs %d", len(names), len(exprs)))
+	}
+	ret := &Env{Parent: parent, Map: make(map[ExprIdent]Expr, len(names)), interp: parent.interp}
+	for i, bind := range names {
+		ret.Map[bind.(ExprIdent)] = exprs[i]
+	}
+	return ret
+}
+
+// newSlotsEnv returns a new `Env` with `names` as its slots, bound to `exprs` if not `nil`.
+func newSlotsEnv(parent *Env, names []Expr, exprs []Expr) *Env {
+	ret := &Env{Parent: parent, names: names, slots: make([]atomic.Pointer[Expr], len(names)), interp: parent.interp}
+	for i := range exprs {
+		ret.slots[i].Store(&exprs[i])
+	}
+	return ret
+}
+
+func (me *Env) isTransparent() bool {
+	return (me.Map == nil) && (me.slots == nil)
+}
+
+func (me *Env) slotOf(name ExprIdent) int {
+	for i, it := range me.names {
+		if it == Expr(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (me *Env) hasOwn(name ExprIdent) (ret bool) {
+	if me.isTransparent() {
+		return me.Parent.hasOwn(name)
+	}
+	if idx := me.slotOf(name); idx >= 0 {
+		return me.slots[idx].Load() != nil
+	}
+	me.mutex.RLock()
+	_, ret = me.Map[name]
+	me.mutex.RUnlock()
+	return
+}
+
+func (me *Env) set(name ExprIdent, value Expr) {
+	if me.isTransparent() {
+		me.Parent.set(name, value)
+		return
+	}
+	if idx := me.slotOf(name); idx >= 0 {
+		me.slots[idx].Store(&value)
+		return
+	}
+	me.mutex.Lock()
+	if me.Map == nil {
+		me.Map = map[ExprIdent]Expr{}
+	}
+	me.Map[name] = value
+	if me == me.interp.Env {
+		if ref := me.interp.globals[name]; ref != nil {
+			ref.Store(&value)
+		}
+	}
+	me.mutex.Unlock()
+}
+
+func (me *Env) find(name ExprIdent) Expr {
+	if idx := me.slotOf(name); idx >= 0 {
+		if found := me.slots[idx].Load(); found != nil {
+			return *found
+		}
+	}
+	me.mutex.RLock()
+	found, ok := me.Map[name]
+	me.mutex.RUnlock()
+	if (!ok) && (me.Parent != nil) {
+		return me.Parent.find(name)
+	}
+	return found
+}
+
+func (me *Env) get(name ExprIdent) (Expr, error) {
+	expr := me.find(name)
+	if expr == nil {
+		return nil, errors.New("undefined: " + string(name))
+	}
+	return expr, nil
+}
+
+// globalRef returns the reference that compiled code reads the current value of `name` in the root `Env` from.
+func (me *Interpreter) globalRef(name ExprIdent) *atomic.Pointer[Expr] {
+	me.Env.mutex.Lock()
+	defer me.Env.mutex.Unlock()
+	ref := me.globals[name]
+	if ref == nil {
+		ref = new(atomic.Pointer[Expr])
+		if found, ok := me.Env.Map[name]; ok {
+			ref.Store(&found)
+		}
+		me.globals[name] = ref
+	}
+	return ref
+}
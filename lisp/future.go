@@ -0,0 +1,192 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ExprPromise holds a result that gets delivered at most once, from whichever goroutine, and can be `await`ed from any.
+// it is made either via `promise` (to be delivered via `deliver`) or via `future` (delivered by its own goroutine).
+type ExprPromise struct {
+	done  chan struct{} // closed on delivery
+	once  sync.Once
+	value Expr
+	err   error // for `future`s whose evaluation failed: returned by `await`
+}
+
+func (*ExprPromise) isExpr() {}
+
+func newPromise() *ExprPromise {
+	return &ExprPromise{done: make(chan struct{})}
+}
+
+func (me *ExprPromise) deliver(value Expr, err error) (delivered bool) {
+	me.once.Do(func() {
+		me.value, me.err, delivered = value, err, true
+		close(me.done)
+	})
+	return
+}
+
+// await waits for delivery, or if `timeout` is positive, at most that long (and then returns `false`). it fails once `ctx` is done.
+func (me *ExprPromise) await(ctx context.Context, timeout time.Duration) (Expr, bool, error) {
+	var timed_out <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timed_out = timer.C
+	}
+	select {
+	case <-me.done:
+		return me.value, true, me.err
+	case <-timed_out:
+		return nil, false, nil
+	case <-ctx.Done():
+		return nil, false, errIfCanceled(ctx)
+	}
+}
+
+func promiseWriteTo(w Writer, it *ExprPromise, srcLike bool) {
+	select {
+	case <-it.done:
+		if it.err != nil {
+			w.WriteString("<promise failed: " + it.err.Error() + ">")
+		} else {
+			w.WriteString("<promise ")
+			exprWriteTo(w, it.value, srcLike)
+			w.WriteByte('>')
+		}
+	default:
+		w.WriteString("<promise pending>")
+	}
+}
+
+// stdFuture evaluates its body on a new goroutine, and returns an `*ExprPromise` for the result: `(future (slowFoo 123))`.
+func stdFuture(env *Env, args []Expr) (*Env, Expr, error) {
+	if err := checkArgsCount(1, -1, "`future`", args); err != nil {
+		return nil, nil, err
+	}
+	body := args[0]
+	if len(args) > 1 {
+		body = append(ExprList{exprIdentDo}, args...)
+	}
+	ret := newPromise()
+	go func() {
+		ret.deliver(recovering(func() (Expr, error) { return evalAndApply(env, body) }))
+	}()
+	return nil, ret, nil
+}
+
+func stdPromise(args []Expr) (Expr, error) {
+	if err := checkArgsCount(0, 0, "`promise`", args); err != nil {
+		return nil, err
+	}
+	return newPromise(), nil
+}
+
+// stdDeliver delivers a value to a promise: `(deliver p 123)`. returns `:false` if it had already been delivered.
+func stdDeliver(args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, 2, "`deliver`", args); err != nil {
+		return nil, err
+	}
+	promise, err := checkIs[*ExprPromise](args[0])
+	if err != nil {
+		return nil, err
+	}
+	return exprBool(promise.deliver(args[1], nil)), nil
+}
+
+// stdAwait waits for and returns the value of a future or promise (`@p` is short for `(await p)`), or at most
+// some milliseconds: `(await p 1000)` returns `:nil` after a second of waiting, `(await p 1000 :timedOut)` returns
+// `:timedOut`. for an atom, it returns its current value right away.
+func stdAwait(args []Expr) (Expr, error) { return await(nil, args) }
+
+func init() {
+	builtinsInEnv[funcAddr(stdAwait)] = await
+	builtinsInEnv[funcAddr(stdPmap)] = pmap
+}
+
+// await is `await`, which also stops waiting once the `context` of `env` is done.
+func await(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 3, "`await`", args); err != nil {
+		return nil, err
+	}
+	if atom, is := args[0].(*ExprAtom); is {
+		return atom.Get(), nil
+	}
+	promise, err := checkIs[*ExprPromise](args[0])
+	if err != nil {
+		return nil, err
+	}
+	var timeout ExprNum
+	if len(args) > 1 {
+		if timeout, err = checkIs[ExprNum](args[1]); err != nil {
+			return nil, err
+		} else if timeout <= 0 {
+			return nil, fmt.Errorf("expected a positive timeout, not %d", timeout)
+		}
+	}
+	value, ok, err := promise.await(env.context(), time.Duration(timeout)*time.Millisecond)
+	if (!ok) && (err == nil) {
+		if len(args) > 2 {
+			return args[2], nil
+		}
+		return exprNil, nil
+	}
+	return value, err
+}
+
+// stdPmap is like `map` but calls `fn` on up to `numWorkers` goroutines at a time (by default, as many as
+// there are CPUs): `(pmap fn list numWorkers)`. the results are in the order of `list`. if any call fails,
+// the error of the first such one in that order is returned, after all calls are done.
+func stdPmap(args []Expr) (Expr, error) { return pmap(nil, args) }
+
+// pmap is `pmap`, which calls `fn` in `env`.
+func pmap(env *Env, args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, 3, "`pmap`", args); err != nil {
+		return nil, err
+	}
+	fn, err := callableIn(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	list, err := checkIsSeq(args[1])
+	if err != nil {
+		return nil, err
+	}
+	num_workers := ExprNum(runtime.GOMAXPROCS(0))
+	if len(args) > 2 {
+		if num_workers, err = checkIs[ExprNum](args[2]); err != nil {
+			return nil, err
+		} else if num_workers <= 0 {
+			return nil, fmt.Errorf("expected a positive number of workers, not %d", num_workers)
+		}
+	}
+
+	ret, errs := make(ExprList, len(list)), make([]error, len(list))
+	idxs := make(chan int)
+	var wait_group sync.WaitGroup
+	for range min(int(num_workers), len(list)) {
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+			for idx := range idxs {
+				ret[idx], errs[idx] = recovering(func() (Expr, error) { return fn([]Expr{list[idx]}) })
+			}
+		}()
+	}
+	for idx := range list {
+		idxs <- idx
+	}
+	close(idxs)
+	wait_group.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,65 @@
+package lisp
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestFuture(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		testEvalAll(t, NewInterpreter(Options{Bytecode: bytecode, MalCompat: true}), [][2]string{
+			{"(await (future (+ 1 2)))", "3"},
+			{"@(future (def x 4) (* x x))", "16"},
+			{"(try (await (future (throw \"oops\"))) (catch err err))", "\"oops\""},
+			{"(try (await (future (meta +))) (catch err :caught))", ":caught"}, // a panic in Go, as a builtin is not hashable
+			{"(def p (promise))", "<promise pending>"},
+			{"(await p 10 :timedOut)", ":timedOut"},
+			{"(deliver p 5)", ":true"},
+			{"(deliver p 6)", ":false"},
+			{"(await p)", "5"},
+			{"(await p 0)", "error: 1:1: expected a positive timeout, not 0"},
+			{"(pmap (fn (n) (* n n)) (list 1 2 3 4 5) 2)", "(1 4 9 16 25)"},
+			{"(pmap (fn (n) (if (= n 3) (throw n) n)) (list 1 2 3 4))", "error: 1:27: 3"},
+			{"(try (pmap meta (list +)) (catch err :caught))", ":caught"},
+		})
+	}
+}
+
+// TestFutureErrStack checks that the error of a failed future, as returned by each `await` of it, gets the stack of only that `await`.
+func TestFutureErrStack(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	for _, src := range []string{
+		`(def f (future (throw "oops")))`,
+		"(def g (fn () (await f)))",
+		"(def h (fn () (+ 1 (g))))",
+	} {
+		if _, err := interp.ReadAndEval("", src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	num_frames := func() int {
+		_, err := interp.ReadAndEval("", "(h)")
+		var stack_err *StackErr
+		if !errors.As(err, &stack_err) {
+			t.Errorf("expected a StackErr, got: %v", err)
+			return -1
+		}
+		return len(stack_err.Stack)
+	}
+	first := num_frames()
+	if again := num_frames(); again != first {
+		t.Errorf("expected %d frames again, got %d", first, again)
+	}
+	var wait_group sync.WaitGroup // for `go test -race`
+	for range 4 {
+		wait_group.Add(1)
+		go func() {
+			defer wait_group.Done()
+			if again := num_frames(); again != first {
+				t.Errorf("expected %d frames concurrently, got %d", first, again)
+			}
+		}()
+	}
+	wait_group.Wait()
+}
@@ -0,0 +1,430 @@
+package lisp
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// conversions between Go values and `Expr`s, for `Bind`ing Go funcs:
+//   - bools to `:true` / `:false`, ints / uints / floats to numbers, strings to `ExprStr`s, `nil`s to `:nil`
+//   - slices and arrays to `ExprList`s, maps to `ExprHashMap`s (with `ExprStr` keys)
+//   - structs to `ExprHashMap`s with one keyword key per exported field: `:name` for field `Name`, or as per a `lisp:"name"` tag (`lisp:"-"` skips it)
+//   - pointers to whatever they point to, funcs to `ExprFunc`s (and `Expr` callables to funcs), errors to `ExprErr`s
+//   - values with methods (such as `*sql.DB` or `time.Time`) and those of no convertible kind to opaque `ExprGo`s, and back
+//   - `Expr`s stay as they are, and `any`s are converted to/from whatever their dynamic value or `Expr` fits best
+
+var (
+	typeError = reflect.TypeFor[error]()
+	typeExpr  = reflect.TypeFor[Expr]()
+)
+
+// goValueMaxDepth is how deeply nested Go values `exprFromGo` converts, so that it fails (rather than overflows the stack) on cyclic ones.
+const goValueMaxDepth = 256
+
+// Bind defines `name` in `me` as an `ExprFunc` that calls `goFunc` (which must be a Go func), after converting its args
+// from `Expr`s and before converting its results back. one result is returned as is, several come as an `ExprList`, none
+// as `:nil`. if the last result is an `error`, it is not part of those but returned as the error if non-`nil`. a panic in
+// `goFunc` is returned as an error, too.
+func (me *Env) Bind(name string, goFunc any) error {
+	fn, err := exprFuncFromGo(name, reflect.ValueOf(goFunc))
+	if err != nil {
+		return err
+	}
+	me.set(ExprIdent(name), fn)
+	return nil
+}
+
+func exprFuncFromGo(name string, fn reflect.Value) (ExprFunc, error) {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, fmt.Errorf("cannot bind `%s`: expected a non-nil func, not %s", name, fn.Type())
+	}
+	ty := fn.Type()
+	num_args_min, num_args_max := ty.NumIn(), ty.NumIn()
+	if ty.IsVariadic() {
+		num_args_min, num_args_max = ty.NumIn()-1, -1
+	}
+	num_results, has_err_result := ty.NumOut(), (ty.NumOut() > 0) && (ty.Out(ty.NumOut()-1) == typeError)
+	if has_err_result {
+		num_results--
+	}
+	return func(args []Expr) (_ Expr, err error) {
+		defer func() {
+			if it := recover(); it != nil {
+				err = fmt.Errorf("panic in `%s`: %v", name, it)
+			}
+		}()
+		if err := checkArgsCount(num_args_min, num_args_max, "`"+name+"`", args); err != nil {
+			return nil, err
+		}
+		go_args := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			arg_type := ty.In(min(i, ty.NumIn()-1))
+			if ty.IsVariadic() && (i >= ty.NumIn()-1) {
+				arg_type = arg_type.Elem()
+			}
+			go_arg, err := exprToGo(arg, arg_type)
+			if err != nil {
+				return nil, fmt.Errorf("arg %d to `%s`: %w", i+1, name, err)
+			}
+			go_args[i] = go_arg
+		}
+
+		results := fn.Call(go_args)
+		if has_err_result {
+			if err, _ := results[num_results].Interface().(error); err != nil {
+				return nil, err
+			}
+		}
+		ret := make(ExprList, num_results)
+		for i := range ret {
+			result, err := exprFromGo(results[i])
+			if err != nil {
+				return nil, fmt.Errorf("result %d of `%s`: %w", i+1, name, err)
+			}
+			ret[i] = result
+		}
+		switch len(ret) {
+		case 0:
+			return exprNil, nil
+		case 1:
+			return ret[0], nil
+		}
+		return ret, nil
+	}, nil
+}
+
+func exprFromGo(it reflect.Value) (Expr, error) {
+	return exprFromGoAt(it, 0)
+}
+
+// exprFromGoAt is `exprFromGo` for `it` nested `depth` levels deep in the value being converted.
+func exprFromGoAt(it reflect.Value, depth int) (Expr, error) {
+	if !it.IsValid() {
+		return exprNil, nil
+	} else if depth > goValueMaxDepth {
+		return nil, fmt.Errorf("cannot convert Go values nested more than %d levels deep (such as cyclic ones) of %s", goValueMaxDepth, it.Type())
+	}
+	if it.Type().Implements(typeExpr) && it.CanInterface() && !((it.Kind() == reflect.Interface) && it.IsNil()) {
+		if expr, _ := it.Interface().(Expr); expr != nil {
+			return expr, nil
+		}
+	}
+	if (it.Kind() != reflect.Interface) && isGoValueHandle(it.Type()) && !((it.Kind() == reflect.Pointer) && it.IsNil()) {
+		if err, _ := it.Interface().(error); err != nil {
+			return ExprErr{It: err}, nil
+		}
+		return ExprGo{It: it.Interface()}, nil
+	}
+	switch it.Kind() {
+	case reflect.Bool:
+		return exprBool(it.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return exprBigInt(big.NewInt(it.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return exprBigInt(new(big.Int).SetUint64(it.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return ExprFloat(it.Float()), nil
+	case reflect.String:
+		return ExprStr(it.String()), nil
+	case reflect.Slice, reflect.Array:
+		list := make(ExprList, it.Len())
+		for i := range list {
+			item, err := exprFromGoAt(it.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	case reflect.Map:
+		hashmap := make(ExprHashMap, it.Len())
+		for iter := it.MapRange(); iter.Next(); {
+			key, err := exprFromGoAt(iter.Key(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			key_str, _, err := checkIsStrOrKeyword(key)
+			if err != nil {
+				return nil, err
+			}
+			if hashmap[key_str], err = exprFromGoAt(iter.Value(), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return hashmap, nil
+	case reflect.Struct:
+		hashmap := ExprHashMap{}
+		for _, field := range goStructFields(it.Type()) {
+			field_value, err := it.FieldByIndexErr(field.Index)
+			if err != nil { // promoted via a `nil` embedded pointer
+				hashmap[field.key] = exprNil
+				continue
+			}
+			value, err := exprFromGoAt(field_value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hashmap[field.key] = value
+		}
+		return hashmap, nil
+	case reflect.Pointer, reflect.Interface:
+		if it.IsNil() {
+			return exprNil, nil
+		} else if err, _ := it.Interface().(error); err != nil {
+			return ExprErr{It: err}, nil
+		}
+		return exprFromGoAt(it.Elem(), depth+1)
+	case reflect.Func:
+		if it.IsNil() {
+			return exprNil, nil
+		}
+		return exprFuncFromGo(it.Type().String(), it)
+	}
+	return nil, fmt.Errorf("cannot convert Go type %s", it.Type())
+}
+
+func exprToGo(expr Expr, ty reflect.Type) (reflect.Value, error) {
+	if go_value, is := expr.(ExprGo); is && reflect.TypeOf(go_value.It).AssignableTo(ty) {
+		return reflect.ValueOf(go_value.It), nil
+	} else if (ty.Kind() == reflect.Interface) && (ty.NumMethod() == 0) {
+		return exprToGoAny(expr, ty)
+	} else if reflect.TypeOf(expr).AssignableTo(ty) {
+		return reflect.ValueOf(expr), nil
+	}
+	ret := reflect.New(ty).Elem()
+	switch ty.Kind() {
+	case reflect.Bool:
+		if (expr != exprTrue) && (expr != exprFalse) {
+			break
+		}
+		ret.SetBool(expr == exprTrue)
+		return ret, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if numRank(expr) == numRankInt || numRank(expr) == numRankBigInt {
+			if big_int := numToBigInt(expr); big_int.IsInt64() && !ret.OverflowInt(big_int.Int64()) {
+				ret.SetInt(big_int.Int64())
+				return ret, nil
+			}
+			return ret, fmt.Errorf("%s overflows %s", str(true, expr), ty)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if numRank(expr) == numRankInt || numRank(expr) == numRankBigInt {
+			if big_int := numToBigInt(expr); big_int.IsUint64() && !ret.OverflowUint(big_int.Uint64()) {
+				ret.SetUint(big_int.Uint64())
+				return ret, nil
+			}
+			return ret, fmt.Errorf("%s overflows %s", str(true, expr), ty)
+		}
+	case reflect.Float32, reflect.Float64:
+		if isNum(expr) {
+			ret.SetFloat(numToFloat(expr))
+			return ret, nil
+		}
+	case reflect.String:
+		if s, is := expr.(ExprStr); is {
+			ret.SetString(string(s))
+			return ret, nil
+		}
+	case reflect.Slice, reflect.Array:
+		list, err := checkIsSeq(expr)
+		if err != nil {
+			break
+		}
+		if ty.Kind() == reflect.Slice {
+			ret = reflect.MakeSlice(ty, len(list), len(list))
+		} else if len(list) != ty.Len() {
+			return ret, fmt.Errorf("expected %d items for %s, not %d", ty.Len(), ty, len(list))
+		}
+		for i, item := range list {
+			go_item, err := exprToGo(item, ty.Elem())
+			if err != nil {
+				return ret, err
+			}
+			ret.Index(i).Set(go_item)
+		}
+		return ret, nil
+	case reflect.Map:
+		hashmap, is := expr.(ExprHashMap)
+		if (!is) || (ty.Key().Kind() != reflect.String) {
+			break
+		}
+		ret = reflect.MakeMapWithSize(ty, len(hashmap))
+		for key, value := range hashmap {
+			go_value, err := exprToGo(value, ty.Elem())
+			if err != nil {
+				return ret, err
+			}
+			ret.SetMapIndex(reflect.ValueOf(key).Convert(ty.Key()), go_value)
+		}
+		return ret, nil
+	case reflect.Struct:
+		hashmap, is := expr.(ExprHashMap)
+		if !is {
+			break
+		}
+		keys := map[string]bool{}
+		for _, field := range goStructFields(ty) {
+			keys[field.key] = true
+			if value, has := hashmap[field.key]; has {
+				go_value, err := exprToGo(value, field.Type)
+				if err != nil {
+					return ret, fmt.Errorf("%s of %s: %w", field.key, ty, err)
+				}
+				ret.FieldByIndex(field.Index).Set(go_value)
+			}
+		}
+		for key := range hashmap {
+			if !keys[key] {
+				return ret, fmt.Errorf("%s has no field for key %s", ty, key)
+			}
+		}
+		return ret, nil
+	case reflect.Pointer:
+		if expr == exprNil {
+			return ret, nil
+		}
+		go_value, err := exprToGo(expr, ty.Elem())
+		if err != nil {
+			return ret, err
+		}
+		ret = reflect.New(ty.Elem())
+		ret.Elem().Set(go_value)
+		return ret, nil
+	case reflect.Func:
+		if expr == exprNil {
+			return ret, nil
+		}
+		return goFuncFromExpr(expr, ty)
+	case reflect.Interface:
+		if expr == exprNil {
+			return ret, nil
+		} else if ty == typeError {
+			ret.Set(reflect.ValueOf(errors.New(str(false, expr))))
+			return ret, nil
+		}
+	}
+	return ret, fmt.Errorf("expected %s, not `%s`", ty, str(true, expr))
+}
+
+// exprToGoAny converts `expr` to the Go value it most naturally corresponds to.
+func exprToGoAny(expr Expr, ty reflect.Type) (reflect.Value, error) {
+	var ty_natural reflect.Type
+	switch it := expr.(type) {
+	case ExprKeyword:
+		switch it {
+		case exprNil:
+			return reflect.New(ty).Elem(), nil
+		case exprTrue, exprFalse:
+			ty_natural = reflect.TypeFor[bool]()
+		}
+	case ExprNum:
+		ty_natural = reflect.TypeFor[int]()
+	case ExprFloat:
+		ty_natural = reflect.TypeFor[float64]()
+	case ExprStr:
+		ty_natural = reflect.TypeFor[string]()
+	case ExprList, ExprVec:
+		ty_natural = reflect.TypeFor[[]any]()
+	case ExprHashMap:
+		ty_natural = reflect.TypeFor[map[string]any]()
+	}
+	if ty_natural == nil {
+		ret := reflect.New(ty).Elem()
+		ret.Set(reflect.ValueOf(expr))
+		return ret, nil
+	}
+	return exprToGo(expr, ty_natural)
+}
+
+// goFuncFromExpr makes a Go func of type `ty` that calls `callee`. if `ty` has an `error` result, it gets any
+// error from that call, else such errors panic. all other results are the zero value on error.
+func goFuncFromExpr(callee Expr, ty reflect.Type) (reflect.Value, error) {
+	call, err := checkIsCallable(callee)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	num_results, has_err_result := ty.NumOut(), (ty.NumOut() > 0) && (ty.Out(ty.NumOut()-1) == typeError)
+	if has_err_result {
+		num_results--
+	}
+	return reflect.MakeFunc(ty, func(goArgs []reflect.Value) []reflect.Value {
+		results := make([]reflect.Value, ty.NumOut())
+		for i := range results {
+			results[i] = reflect.New(ty.Out(i)).Elem()
+		}
+		fail := func(err error) []reflect.Value {
+			if !has_err_result {
+				panic(err)
+			}
+			results[num_results] = reflect.ValueOf(&err).Elem()
+			return results
+		}
+
+		if ty.IsVariadic() {
+			variadic := goArgs[len(goArgs)-1]
+			goArgs = goArgs[:len(goArgs)-1]
+			for i := range variadic.Len() {
+				goArgs = append(goArgs, variadic.Index(i))
+			}
+		}
+		args := make([]Expr, len(goArgs))
+		for i, go_arg := range goArgs {
+			arg, err := exprFromGo(go_arg)
+			if err != nil {
+				return fail(err)
+			}
+			args[i] = arg
+		}
+		expr, err := call(args)
+		if err != nil {
+			return fail(err)
+		}
+
+		exprs := []Expr{expr}
+		if num_results == 0 {
+			exprs = nil
+		} else if num_results > 1 {
+			if exprs, err = checkIsSeq(expr); err != nil {
+				return fail(err)
+			} else if len(exprs) != num_results {
+				return fail(fmt.Errorf("expected %d results, not %d", num_results, len(exprs)))
+			}
+		}
+		for i, expr := range exprs {
+			result, err := exprToGo(expr, ty.Out(i))
+			if err != nil {
+				return fail(err)
+			}
+			results[i] = result
+		}
+		return results
+	}), nil
+}
+
+type goStructField struct {
+	reflect.StructField
+	key string
+}
+
+// goStructFields returns the exported fields of `ty` that are to be converted, with their hashmap keys.
+func goStructFields(ty reflect.Type) (ret []goStructField) {
+	for _, field := range reflect.VisibleFields(ty) {
+		if (!field.IsExported()) || field.Anonymous {
+			continue
+		}
+		name := field.Tag.Get("lisp")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			first_rune, n := utf8.DecodeRuneInString(field.Name)
+			name = string(unicode.ToLower(first_rune)) + field.Name[n:]
+		}
+		ret = append(ret, goStructField{field, ":" + strings.TrimPrefix(name, ":")})
+	}
+	return
+}
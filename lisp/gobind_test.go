@@ -0,0 +1,38 @@
+package lisp
+
+import "testing"
+
+type testNode struct {
+	Name string
+	Next *testNode
+}
+
+type testPanicker struct{}
+
+func (*testPanicker) Boom() { panic("boom") }
+
+func TestBind(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	cyclic := &testNode{Name: "cyclic"}
+	cyclic.Next = cyclic
+	for name, fn := range map[string]any{
+		"add":      func(a int, b int) int { return a + b },
+		"divide":   func(a int, b int) int { return a / b },
+		"nodes":    func() *testNode { return &testNode{Name: "first", Next: &testNode{Name: "second"}} },
+		"cyclic":   func() *testNode { return cyclic },
+		"panicker": func() *testPanicker { return &testPanicker{} },
+	} {
+		if err := interp.Env.Bind(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testEvalAll(t, interp, [][2]string{
+		{"(add 1 2)", "3"},
+		{"(divide 6 3)", "2"},
+		{"(divide 1 0)", "error: 1:1: panic in `divide`: runtime error: integer divide by zero"},
+		{"(hashmapGet (hashmapGet (nodes) :next) :name)", "\"second\""},
+		{"(hashmapGet (hashmapGet (nodes) :next) :next)", ":nil"},
+		{"(cyclic)", "error: 1:1: result 1 of `cyclic`: cannot convert Go values nested more than 256 levels deep (such as cyclic ones) of lisp.testNode"},
+		{"(goCall (panicker) \"Boom\")", "error: 1:1: panic in `*lisp.testPanicker.Boom`: boom"},
+	})
+}
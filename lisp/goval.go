@@ -0,0 +1,93 @@
+package lisp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ExprGo holds any Go value that has no natural `Expr` counterpart, such as handles like `*sql.DB` or `*os.File`.
+// scripts pass these around untouched, and can call their exported methods via `goCall` and read their fields via `goField`.
+type ExprGo struct{ It any }
+
+func (ExprGo) isExpr() {}
+
+// isGoValueHandle tells whether `exprFromGo` wraps a value of type `ty` into an `ExprGo`, rather than converting it:
+// it does so for all values that have methods (other than `error`s and `Expr`s), and for those without any conversion.
+func isGoValueHandle(ty reflect.Type) bool {
+	switch ty.Kind() {
+	case reflect.Pointer, reflect.Struct:
+		return ty.NumMethod() > 0
+	case reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+func goValuesEq(it1 ExprGo, it2 ExprGo) bool {
+	value1, value2 := reflect.ValueOf(it1.It), reflect.ValueOf(it2.It)
+	return (value1.Type() == value2.Type()) && value1.Comparable() && value1.Equal(value2)
+}
+
+func goValueWriteTo(w Writer, it ExprGo, srcLike bool) {
+	if srcLike {
+		w.WriteString(fmt.Sprintf("<go %T %v>", it.It, it.It))
+	} else {
+		w.WriteString(fmt.Sprint(it.It))
+	}
+}
+
+// stdGoCall calls an exported method: `(goCall file "WriteString" "foo")`. args and results convert as for `Env.Bind`.
+func stdGoCall(args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, -1, "`goCall`", args); err != nil {
+		return nil, err
+	}
+	it, err := checkIs[ExprGo](args[0])
+	if err != nil {
+		return nil, err
+	}
+	name, err := checkIs[ExprStr](args[1])
+	if err != nil {
+		return nil, err
+	}
+	method := reflect.ValueOf(it.It).MethodByName(string(name))
+	if !method.IsValid() {
+		return nil, fmt.Errorf("%T has no exported method `%s`", it.It, name)
+	}
+	fn, err := exprFuncFromGo(fmt.Sprintf("%T.%s", it.It, name), method)
+	if err != nil {
+		return nil, err
+	}
+	return fn(args[2:])
+}
+
+// stdGoField reads an exported field of a struct (or of a pointer to one): `(goField req "Method")`.
+func stdGoField(args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, 2, "`goField`", args); err != nil {
+		return nil, err
+	}
+	it, err := checkIs[ExprGo](args[0])
+	if err != nil {
+		return nil, err
+	}
+	name, err := checkIs[ExprStr](args[1])
+	if err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(it.It)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, fmt.Errorf("cannot read field `%s` of nil %T", name, it.It)
+		}
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Struct {
+		if field, ok := value.Type().FieldByName(string(name)); ok && field.IsExported() {
+			field_value, err := value.FieldByIndexErr(field.Index)
+			if err != nil { // promoted via a `nil` embedded pointer
+				return nil, fmt.Errorf("cannot read field `%s` of %T: %w", name, it.It, err)
+			}
+			return exprFromGo(field_value)
+		}
+	}
+	return nil, fmt.Errorf("%T has no exported field `%s`", it.It, name)
+}
@@ -0,0 +1,215 @@
+package lisp
+
+import (
+	"cmp"
+	"strings"
+)
+
+const fakeFuncNamesForDebugging = false // costly but can aid the occasional trouble-shooting
+
+// to confirm TCO still works, uncomment the 2 commented lines in `evalAndApply` below that are referring to `id`.
+// another way: run `(sum2 10000000 0)` with `Options.NoTco` (stack overflow) and then without (no stack overflow), where `sum2` is in github.com/kanaka/mal/blob/master/impls/tests/step5_tco.mal
+
+func evalAndApply(env *Env, expr Expr) (ret Expr, err error) {
+	// id := time.Now().UnixNano()
+	interp := env.interp
+	var depth int // only tracked for `Sandbox.MaxDepth`
+	if interp.sandbox != nil {
+		if depth, err = interp.sandboxDepth(env); err != nil {
+			return nil, err
+		}
+	}
+	var frame *StackFrame // the `*ExprFn` we're currently (tail-)evaluating the body of, if any
+	var traced []string   // the names of all traced `*ExprFn`s (tail-)called in here: they all return when we do
+	var prof *profFrame   // the `Profiler`'s record of `frame`, if any
+	var nesting int       // only tracked for the `Debugger`
+	debugging, covering := interp.Debugger.on.Load(), interp.Coverage.on.Load()
+	if debugging {
+		nesting = int(interp.Debugger.nesting.Add(1))
+	}
+	defer func() {
+		if debugging {
+			interp.Debugger.nesting.Add(-1)
+		}
+		for i := len(traced) - 1; i >= 0; i-- {
+			interp.Tracer.ret(traced[i], ret, err)
+		}
+		interp.Profiler.ret(prof)
+		if (err != nil) && (frame != nil) {
+			err = errWithFrame(err, *frame)
+		}
+	}()
+	for env != nil {
+		// println("ITER", id, printExpr(expr, true))
+		ctx := env.context()
+		if err = errIfCanceled(ctx); err != nil {
+			return nil, err
+		} else if interp.sandbox != nil {
+			if err = interp.sandboxStep(ctx); err != nil {
+				return nil, err
+			}
+		}
+		call, _ := expr.(*tailCall)
+		if code, is_compiled := expr.(compiled); is_compiled {
+			if env, expr, err = code(env); err != nil {
+				return nil, err
+			} else if call, _ = expr.(*tailCall); call == nil {
+				continue
+			}
+		} else if call == nil {
+			form, is_list := expr.(ExprList) // the form as read, for error locations: `it` below might be a macro expansion
+			if debugging && is_list && (len(form) > 0) {
+				if err = interp.Debugger.atForm(env, form, nesting, frame); err != nil {
+					return nil, err
+				}
+			}
+			if covering && is_list && (len(form) > 0) {
+				interp.Coverage.ran(form)
+			}
+			if it := form; (!is_list) || (len(it) == 0) {
+				expr, err = evalExpr(env, expr)
+				env = nil
+			} else if expr, err = macroExpand(env, it); err != nil {
+				return nil, errAt(form, err)
+			} else if it, is_list = expr.(ExprList); is_list && (len(it) > 0) { // macroExpand might have returned a non-list
+				var special_form SpecialForm
+				if ident, _ := it[0].(ExprIdent); ident != "" {
+					special_form = interp.specialForms[ident]
+				}
+				if special_form != nil {
+					if env, expr, err = special_form(env, it[1:]); err != nil {
+						return nil, errAt(form, err)
+					}
+				} else {
+					maybe_ident, _ := it[0].(ExprIdent)
+					if fakeFuncNamesForDebugging && maybe_ident == "" {
+						maybe_ident = ExprIdent(str(true, it))
+					}
+					if expr, err = evalExpr(env, it); err != nil {
+						return nil, errAt(form, err)
+					}
+					list := expr.(ExprList)
+					call = &tailCall{callee: list[0], args: list[1:], name: string(maybe_ident), form: form}
+				}
+			}
+			if call == nil {
+				continue
+			}
+		}
+
+		switch fn := call.callee.(type) {
+		default:
+			return nil, errAtSpan(call.srcSpan(), newErrNotCallable(call.callee))
+		case ExprFunc:
+			if debugging {
+				if err = interp.Debugger.atCall(env, call.name, call.args, call.srcSpan(), debugLevel(nesting, frame != nil), nil); err != nil {
+					return nil, err
+				}
+			}
+			if expr, err = interp.callFunc(env, fn, call); err != nil {
+				return nil, err
+			}
+			env = nil
+		case *ExprFn:
+			frame = &StackFrame{Name: cmp.Or(strings.Trim(fn.nameMaybe, "`"), call.name), Span: call.srcSpan()}
+			if name := frame.Name; interp.Tracer.isTraced(name) {
+				interp.Tracer.call(name, call.args)
+				traced = append(traced, name)
+			}
+			if (prof != nil) || interp.Profiler.on.Load() {
+				prof = interp.Profiler.call(fn, frame.Name, env, prof)
+			}
+			if env, err = fn.envWith(call.args); err != nil {
+				return nil, errAtSpan(frame.Span, err)
+			}
+			env.ctx = ctx // the caller's, not that of wherever `fn` was defined
+			env.depth, env.prof = depth, prof
+			if debugging {
+				if err = interp.Debugger.atCall(env, frame.Name, call.args, frame.Span, debugLevel(nesting, true), frame); err != nil {
+					return nil, err
+				}
+			}
+			expr = fn.body
+			if code := fn.compiled(); code != nil {
+				expr = code
+			}
+		}
+	}
+	return expr, err
+}
+
+// builtinsInEnv are, keyed by the code addresses of the `ExprFunc` builtins they are for, the variants of those that get
+// the `Env` they are called in: for builtins that block, to stop once its `context` is done, and for those that call `fn`s,
+// to call them in it (see `callableIn`). those `ExprFunc`s themselves are these called with a `nil` `Env`, for calls from
+// Go (such as by bound Go funcs), outside of any evaluation.
+var builtinsInEnv = map[uintptr]func(*Env, []Expr) (Expr, error){}
+
+// callFunc calls `fn`, which is `call.callee`, in `env`, for `evalAndApply`, `compiled` code and the VM alike.
+func (me *Interpreter) callFunc(env *Env, fn ExprFunc, call *tailCall) (ret Expr, err error) {
+	if in_env := builtinsInEnv[funcAddr(fn)]; in_env != nil {
+		fn = func(args []Expr) (Expr, error) { return in_env(env, args) }
+	}
+	if me.Tracer.isTraced(call.name) {
+		me.Tracer.call(call.name, call.args)
+		ret, err = fn(call.args)
+		me.Tracer.ret(call.name, ret, err)
+	} else {
+		ret, err = fn(call.args)
+	}
+	if (err == nil) && (me.sandbox != nil) {
+		err = me.sandboxAlloc(env.context(), ret)
+	}
+	if err != nil {
+		span := call.srcSpan()
+		return nil, errWithFrame(errAtSpan(span, err), StackFrame{Name: call.name, Span: span})
+	}
+	return ret, nil
+}
+
+func evalExpr(env *Env, expr Expr) (Expr, error) {
+	switch it := expr.(type) {
+	case ExprIdent:
+		return env.get(it)
+	case ExprHashMap:
+		var err error
+		hash_map := make(ExprHashMap, len(it))
+		for key, value := range it {
+			hash_map[key], err = evalAndApply(env, value)
+			if err != nil {
+				return nil, errAt(it, err)
+			}
+		}
+		if env.interp.sandbox != nil {
+			if err = env.interp.sandboxAlloc(env.context(), hash_map); err != nil {
+				return nil, err
+			}
+		}
+		return hash_map, nil
+	case ExprVec:
+		var err error
+		vec := make(ExprVec, len(it))
+		for i, item := range it {
+			vec[i], err = evalAndApply(env, item)
+			if err != nil {
+				return nil, errAtItem(it, i, err)
+			}
+		}
+		if env.interp.sandbox != nil {
+			if err = env.interp.sandboxAlloc(env.context(), vec); err != nil {
+				return nil, err
+			}
+		}
+		return vec, nil
+	case ExprList:
+		var err error
+		list := make(ExprList, len(it))
+		for i, item := range it {
+			list[i], err = evalAndApply(env, item)
+			if err != nil {
+				return nil, errAtItem(it, i, err)
+			}
+		}
+		return list, nil
+	}
+	return expr, nil
+}
@@ -0,0 +1,321 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Interpreter is one independent go-lisp instance: it owns its root `Env` (and so all top-level `def`s),
+// its special forms, its I/O streams and its `Options`. any number of them can coexist in one process.
+type Interpreter struct {
+	Env      *Env // the root env, pre-populated with the std funcs and the mini-stdlib
+	Options  Options
+	Tracer   Tracer
+	Debugger Debugger
+	Profiler Profiler
+	Coverage Coverage
+	Stdin    io.Reader // for `readLine` and `(loadFile "-")`
+	Stdout   io.Writer // for `print` and `println`
+	Stderr   io.Writer // for `Repl` error messages
+
+	specialForms map[ExprIdent]SpecialForm
+	malMeta      sync.Map                            // `Expr` keys and values
+	sandbox      *sandboxEval                        // non-`nil` only with `Options.Sandbox` and only once the mini-stdlib is loaded
+	globals      map[ExprIdent]*atomic.Pointer[Expr] // guarded by `Env.mutex`: see `globalRef`
+	macroDefs    atomic.Int64                        // how often a `def` or `set` bound a macro (or rebound a name bound to one): see `fnBody`
+	tests        interpTests
+}
+
+type Options struct {
+	// the value of `osArgs`, usually the command-line args following the source file path
+	OsArgs []string
+	// adds the aliases and helpers needed to run the github.com/kanaka/mal step tests and `.mal` files
+	MalCompat bool
+	// makes all `fn`s other than `macro`s plain `ExprFunc`s that call `evalAndApply` anew (on the Go stack) for their body.
+	// this is just for quick temporary trouble-shootings to see if TCO got somehow broken.
+	NoTco bool
+	// if not `nil`, limits what scripts can do, for running untrusted ones
+	Sandbox *Sandbox
+	// compiles each top-level form (and each `fn` body, on its first call) to bytecode run by a stack-based VM,
+	// instead of interpreting it in `evalAndApply` (and compiling `fn` bodies to Go closures)
+	Bytecode bool
+}
+
+// NewInterpreter returns a new `Interpreter` with its std funcs and mini-stdlib loaded, reading from `os.Stdin`
+// and writing to `os.Stdout` and `os.Stderr` (and all call traces to the latter) unless changed afterwards.
+func NewInterpreter(options Options) *Interpreter {
+	me := &Interpreter{
+		Options:      options,
+		Tracer:       Tracer{names: map[string]bool{}},
+		Debugger:     Debugger{In: os.Stdin, Out: os.Stderr, fns: map[string]bool{}, lines: map[int][]string{}},
+		Stdin:        os.Stdin,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+		specialForms: maps.Clone(specialForms),
+		globals:      map[ExprIdent]*atomic.Pointer[Expr]{},
+	}
+	me.Env, me.Coverage.interp, me.Tracer.interp = &Env{Map: maps.Clone(envStd), interp: me}, me, me
+	for name, fn := range map[ExprIdent]ExprFunc{
+		"print":    me.stdPrint,
+		"println":  me.stdPrintln,
+		"eval":     me.stdEval,
+		"loadFile": me.stdLoadFile,
+		"readLine": me.stdReadLine,
+		"at":       me.stdListAt,
+		"traceSet": me.stdTraceSet,
+		"go":       me.stdGo,
+	} {
+		me.Env.Map[name] = fn
+	}
+	os_args := make(ExprList, 0, len(options.OsArgs))
+	for _, arg := range options.OsArgs {
+		os_args = append(os_args, ExprStr(arg))
+	}
+	me.Env.Map["osArgs"] = os_args
+	if options.Sandbox != nil {
+		for _, name := range sandboxOmitted {
+			delete(me.Env.Map, name)
+		}
+		delete(me.specialForms, "debug")
+		delete(me.specialForms, "future")
+	}
+
+	// load in the mini-stdlib
+	for _, src := range [][2]string{{"<srcMiniStdlibMacros>", srcMiniStdlibMacros}, {"<srcMiniStdlibNonMacros>", srcMiniStdlibNonMacros}} {
+		if _, err := me.ReadAndEval(src[0], "("+string(exprIdentDo)+" "+src[1]+"\n"+string(exprNil)+")"); err != nil {
+			panic(err)
+		}
+	}
+	if options.MalCompat {
+		me.ensureMALCompatibility()
+	}
+	if options.Sandbox != nil {
+		me.sandbox = &sandboxEval{}
+	}
+	return me
+}
+
+// Eval evaluates `expr` in `me.Env`.
+func (me *Interpreter) Eval(expr Expr) (Expr, error) {
+	if me.sandbox != nil {
+		return me.EvalContext(context.Background(), expr)
+	}
+	return me.eval(me.Env, expr)
+}
+
+// EvalContext is like `Eval`, but stops with an error once `ctx` is done.
+func (me *Interpreter) EvalContext(ctx context.Context, expr Expr) (Expr, error) {
+	return me.evalContextIn(ctx, me.Env, expr)
+}
+
+// evalContextIn is `EvalContext` but in `env`, which is `me.Env` or a descendant of it.
+func (me *Interpreter) evalContextIn(ctx context.Context, env *Env, expr Expr) (Expr, error) {
+	if me.sandbox != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = me.sandboxContext(ctx)
+		defer cancel()
+	}
+	return me.eval(newCtxEnv(env, ctx), expr)
+}
+
+// eval evaluates `expr` in `env` with the VM if `Options.Bytecode` (and not `interpretOnly`), else with `evalAndApply`.
+func (me *Interpreter) eval(env *Env, expr Expr) (Expr, error) {
+	if me.Options.Bytecode && !me.interpretOnly() {
+		return vmEval(env, expr)
+	}
+	return evalAndApply(env, expr)
+}
+
+// interpretOnly returns whether all evaluation is to happen in `evalAndApply` (rather than in compiled code), for the `Debugger` or the `Coverage` to see every form.
+func (me *Interpreter) interpretOnly() bool {
+	return me.Debugger.on.Load() || me.Coverage.on.Load()
+}
+
+// ReadAndEval reads the single form in `src` and evaluates it in `me.Env`.
+func (me *Interpreter) ReadAndEval(srcFilePath string, src string) (Expr, error) {
+	expr, err := readExpr(srcFilePath, src)
+	if err != nil || expr == nil {
+		return nil, err
+	}
+	return me.Eval(expr)
+}
+
+// Load evaluates each top-level form in `src` in `me.Env` right after reading it, until the first error.
+func (me *Interpreter) Load(srcFilePath string, src io.Reader) error {
+	forms := newFormReader(srcFilePath, src)
+	for {
+		expr, err := forms.ReadForm()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if me.Coverage.on.Load() {
+			me.Coverage.read(srcFilePath, expr)
+		}
+		if _, err = me.Eval(expr); err != nil {
+			return err
+		}
+	}
+}
+
+// LoadFile is like `Load` for the specified source file, or for `me.Stdin` if `srcFilePath` is "-".
+func (me *Interpreter) LoadFile(srcFilePath string) error {
+	if srcFilePath == "-" {
+		return me.Load("<stdin>", me.Stdin)
+	}
+	file, err := os.Open(srcFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return me.Load(srcFilePath, file)
+}
+
+// Repl runs the read-eval-print loop on `me.Stdin` until it ends (then returning 0), or until `quit` or `exit` is called (then returning its exit code).
+func (me *Interpreter) Repl() (exitCode int) {
+	const prompt, promptMidForm = "࿊  ", "…  "
+	stdin := me.Stdin
+	line_editor := me.lineEditor() // `nil` if not on an interactive terminal
+	if line_editor != nil {
+		line_editor.Env, stdin = me.Env, line_editor
+	}
+	forms := newFormReader("<repl>", stdin)
+	if line_editor == nil { // so that `(debug expr)` reads its commands from the lines following it
+		me.Debugger.readFrom(stdin, forms.src)
+	}
+	forms.onMoreInput = func(isMidForm bool) {
+		prompt := prompt
+		if isMidForm {
+			prompt = promptMidForm
+		} else {
+			fmt.Fprintln(me.Stdout)
+		}
+		if line_editor != nil {
+			line_editor.Prompt = prompt
+		} else {
+			fmt.Fprint(me.Stdout, prompt)
+		}
+	}
+	for {
+		expr, err := forms.ReadForm()
+		if err == io.EOF {
+			return 0
+		} else if err == errInputCanceled {
+			continue
+		} else if err == nil {
+			expr, err = me.evalInterruptibly(expr)
+		}
+		var exit_err *ExitErr
+		if errors.As(err, &exit_err) {
+			return exit_err.Code
+		} else if err != nil {
+			forms.DiscardPending() // dont run any further forms from the same line after an error
+			msg := err.Error()
+			_, _ = io.WriteString(me.Stderr, strings.Repeat("~", 2+len(msg))+"\n "+ErrStackTrace(err)+"\n"+strings.Repeat("~", 2+len(msg))+"\n")
+		} else if output := str(true, expr); output != "" {
+			fmt.Fprintln(me.Stdout, output)
+		}
+	}
+}
+
+// evalInterruptibly evaluates `expr` like `Eval`, but cancels it on the first SIGINT (such as via Ctrl+C), leaving the
+// second to the default handling (of exiting the process), for when it is blocked in Go code not checking for cancellation.
+func (me *Interpreter) evalInterruptibly(expr Expr) (Expr, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigint, done := make(chan os.Signal, 1), make(chan struct{})
+	signal.Notify(sigint, os.Interrupt)
+	defer func() {
+		signal.Stop(sigint)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-sigint:
+			signal.Stop(sigint)
+			cancel(errInterrupted)
+		case <-done:
+		}
+	}()
+	return me.EvalContext(ctx, expr)
+}
+
+// lineEditor returns the `LineEditor` on the interactive terminal if `me.Stdin` is on it, else `nil`.
+func (me *Interpreter) lineEditor() *LineEditor {
+	if me.Stdin != io.Reader(os.Stdin) {
+		return nil
+	}
+	return stdinLineEditor()
+}
+
+const srcMiniStdlibNonMacros = `
+
+
+(def not
+	(fn (any)
+		(if any :false :true)))
+
+(def nth at)
+
+(def first
+	(fn (list)
+		(at list 0)))
+
+(def rest
+	(fn (list)
+		(at list 1 -1)))
+
+(def map
+	(fn (func list)
+		(if (isEmpty list)
+			()
+			(cons (func (first list)) (map func (rest list))))))
+
+`
+
+const srcMiniStdlibMacros = `
+
+
+(def caseOf
+	(macro (cases)
+		(if (isEmpty cases)
+			:nil
+			(let (	(case (at cases 0))
+					(case_cond (at case 0))
+					(case_then (at case 1)))
+				´(if ~case_cond
+						~case_then
+						(caseOf ~(rest cases)))))))
+
+(def and
+	(macro (any1 any2)
+		´(if ~any1 ~any2 :false)))
+
+(def or
+	(macro (any1 any2)
+		´(if ~any1 ~any1 ~any2)))
+
+(def postfix ;;; turns (1 2 +) into (+ 1 2)
+	(macro (call)
+		(if (and (is :list call) (> (count call) 1))
+			(cons (at call -1) (at call 0 -2))
+			call)))
+
+(def trace ;;; (trace foo bar) logs all calls to foo and bar, (trace *) to all functions
+	(macro (& names)
+		´(traceSet :true (quote ~names))))
+
+(def untrace ;;; (untrace foo) stops logging calls to foo, (untrace) stops all call tracing
+	(macro (& names)
+		´(traceSet :false (quote ~names))))
+
+
+`
@@ -0,0 +1,29 @@
+package lisp
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestExit checks that `exit` ends the evaluation with an `*ExitErr` (rather than the process), uncaught by `try`.
+func TestExit(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		interp := NewInterpreter(Options{Bytecode: bytecode})
+		for src, code := range map[string]int{
+			"(exit)":                                      0,
+			"(do (exit 3) (throw \"unreached\"))":         3,
+			"(try (quit 4) (catch err :caught))":          4,
+			"(try ((fn () (exit 5))) (catch err 0))":      5,
+			"(assertThrows (exit 6))":                     6,
+			"(try (apply exit (list 300)) (catch err 0))": 255,
+		} {
+			_, err := interp.ReadAndEval("", src)
+			var exit_err *ExitErr
+			if !errors.As(err, &exit_err) {
+				t.Errorf("bytecode=%v: %s: expected an ExitErr, got: %v", bytecode, src, err)
+			} else if exit_err.Code != code {
+				t.Errorf("bytecode=%v: %s: expected exit code %d, got %d", bytecode, src, code, exit_err.Code)
+			}
+		}
+	}
+}
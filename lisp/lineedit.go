@@ -0,0 +1,208 @@
+package lisp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+const lineHistoryMaxLen = 1000
+
+// returned by `LineEditor` reads when the user hit Ctrl+C: only the current input is abandoned, unlike with `io.EOF`
+var errInputCanceled = errors.New("input canceled")
+
+// LineEditor reads lines from an interactive terminal, with arrow-key editing, a persistent
+// history and tab-completion of identifiers. it is also an `io.Reader` (for `FormReader`s).
+type LineEditor struct {
+	Prompt string // used by `Read`
+	Env    *Env   // for tab-completion: the identifiers bound in this env and its parents (and special-form names), such as the `Debugger`'s paused-in env
+
+	term    *term.Terminal
+	fd      int
+	history lineHistory
+	ctrlC   bool
+	pending string // for `Read`: the not-yet-consumed rest of the last line read
+
+	completions       []string // for cycling through ambiguous completions on repeated Tab presses
+	completionIdx     int
+	completionLine    string // the line as it was after the last completion
+	completionIdxWord int    // where in `completionLine` the word being completed starts
+}
+
+// stdinLineEditor returns the `LineEditor` shared by the REPL and `readLine`, or `nil` if not on an interactive terminal.
+var stdinLineEditor = sync.OnceValue(func() *LineEditor {
+	fd := int(os.Stdin.Fd())
+	if !(term.IsTerminal(fd) && term.IsTerminal(int(os.Stdout.Fd()))) {
+		return nil
+	}
+	me := &LineEditor{fd: fd}
+	me.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{ctrlCSpotter{me, os.Stdin}, os.Stdout}, "")
+	me.term.History, me.term.AutoCompleteCallback = me, me.complete
+	if dir_path, err := os.UserConfigDir(); err == nil {
+		me.history.load(filepath.Join(dir_path, "go-lisp", "history"))
+	}
+	return me
+})
+
+// ReadLine prompts for and reads one line of input, without the line break.
+func (me *LineEditor) ReadLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(me.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(me.fd, state)
+	if width, height, err := term.GetSize(me.fd); (err == nil) && (width > 0) {
+		_ = me.term.SetSize(width, height)
+	}
+
+	me.term.SetPrompt(prompt)
+	me.ctrlC = false
+	line, err := me.term.ReadLine()
+	if (err == nil) && me.ctrlC {
+		_, _ = me.term.Write([]byte("^C\n"))
+		line, err = "", errInputCanceled
+	}
+	return line, err
+}
+
+// Add, Len and At implement `term.History` for `me.term`.
+func (me *LineEditor) Add(entry string) {
+	if !me.ctrlC {
+		me.history.add(entry)
+	}
+}
+func (me *LineEditor) Len() int          { return len(me.history.entries) }
+func (me *LineEditor) At(idx int) string { return me.history.entries[len(me.history.entries)-1-idx] }
+
+func (me *LineEditor) Read(buf []byte) (int, error) {
+	if me.pending == "" {
+		line, err := me.ReadLine(me.Prompt)
+		if err != nil {
+			return 0, err
+		}
+		me.pending = line + "\n"
+	}
+	n := copy(buf, me.pending)
+	me.pending = me.pending[n:]
+	return n, nil
+}
+
+func (me *LineEditor) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	if (me.completionLine == line) && (len(me.completions) > 1) { // repeated Tab: cycle through the candidates
+		word := line[me.completionIdxWord:pos]
+		for word_prev := word; word == word_prev; {
+			me.completionIdx = (me.completionIdx + 1) % len(me.completions)
+			word = me.completions[me.completionIdx]
+		}
+		line_new := line[:me.completionIdxWord] + word + line[pos:]
+		me.completionLine = line_new
+		return line_new, me.completionIdxWord + len(word), true
+	}
+
+	idx_word := strings.LastIndexAny(line[:pos], " \t,()[]{}'\"`´~@;") + 1
+	prefix := line[idx_word:pos]
+	if prefix == "" {
+		return "", 0, false
+	}
+	me.completions, me.completionIdx = nil, -1
+	add := func(name ExprIdent) {
+		if strings.HasPrefix(string(name), prefix) && !slices.Contains(me.completions, string(name)) {
+			me.completions = append(me.completions, string(name))
+		}
+	}
+	for env := me.Env; env != nil; env = env.Parent {
+		for _, name := range env.names { // the params of a `fn` call or the names of a compiled `let`
+			add(name.(ExprIdent))
+		}
+		env.mutex.RLock()
+		for name := range env.Map {
+			add(name)
+		}
+		env.mutex.RUnlock()
+	}
+	if me.Env != nil {
+		for name := range me.Env.interp.specialForms {
+			add(name)
+		}
+	}
+	if len(me.completions) == 0 {
+		return "", 0, false
+	}
+	slices.Sort(me.completions)
+
+	word := me.completions[0] // extend to the longest prefix shared by all candidates
+	for _, it := range me.completions[1:] {
+		for !strings.HasPrefix(it, word) {
+			word = word[:len(word)-1]
+		}
+	}
+	line_new := line[:idx_word] + word + line[pos:]
+	me.completionLine, me.completionIdxWord = line_new, idx_word
+	return line_new, idx_word + len(word), true
+}
+
+// ctrlCSpotter notes on its `LineEditor` whenever a Ctrl+C key press comes through, and turns it into an Enter key press
+// instead, to have `term.Terminal.ReadLine` return. (`term.Terminal` treats Ctrl+C like Ctrl+D and then keeps re-reading it.)
+type ctrlCSpotter struct {
+	lineEditor *LineEditor
+	io.Reader
+}
+
+func (me ctrlCSpotter) Read(buf []byte) (int, error) {
+	n, err := me.Reader.Read(buf)
+	if idx := slices.Index(buf[:n], 3); idx >= 0 {
+		me.lineEditor.ctrlC, buf[idx], n = true, '\r', idx+1 // any further input is dropped along with the line
+	}
+	return n, err
+}
+
+// lineHistory holds the entries for `LineEditor`s `term.History` implementation, appending each new one to its history file (if any).
+type lineHistory struct {
+	entries  []string // oldest first
+	filePath string
+}
+
+func (me *lineHistory) load(filePath string) {
+	me.filePath = filePath
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	for lines := bufio.NewScanner(file); lines.Scan(); {
+		me.entries = append(me.entries, lines.Text())
+	}
+	if len(me.entries) > lineHistoryMaxLen {
+		me.entries = me.entries[len(me.entries)-lineHistoryMaxLen:]
+		_ = os.WriteFile(filePath, []byte(strings.Join(me.entries, "\n")+"\n"), 0600)
+	}
+}
+
+func (me *lineHistory) add(entry string) {
+	if (strings.TrimSpace(entry) == "") || ((len(me.entries) > 0) && (me.entries[len(me.entries)-1] == entry)) {
+		return
+	}
+	if me.entries = append(me.entries, entry); len(me.entries) > lineHistoryMaxLen {
+		me.entries = me.entries[1:]
+	}
+	if me.filePath != "" {
+		_ = os.MkdirAll(filepath.Dir(me.filePath), 0700)
+		if file, err := os.OpenFile(me.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+			_, _ = file.WriteString(entry + "\n")
+			_ = file.Close()
+		}
+	}
+}
@@ -1,8 +1,8 @@
-package main
+package lisp
 
 func macroExpand(env *Env, expr Expr) (Expr, error) {
 	for callee := macroCallCallee(env, expr); callee != nil; callee = macroCallCallee(env, expr) {
-		it, err := callee.Call(expr.(ExprList)[1:])
+		it, err := callee.callIn(env, expr.(ExprList)[1:])
 		if err != nil {
 			return nil, err
 		}
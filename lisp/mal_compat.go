@@ -1,17 +1,13 @@
-package main
+package lisp
 
 import (
 	"fmt"
-	"os"
+	"io"
+	"slices"
 	"strings"
 )
 
-var (
-	malCompat = (os.Getenv("MAL_COMPAT") != "")
-	malMeta   = map[Expr]Expr{}
-)
-
-func ensureMALCompatibility() {
+func (me *Interpreter) ensureMALCompatibility() {
 	// simple aliases: special-forms
 	for mals, ours := range map[ExprIdent]ExprIdent{
 		"def!":        "def",
@@ -20,15 +16,14 @@ func ensureMALCompatibility() {
 		"quasiquote":  "quasiQuote",
 		"macroexpand": "macroExpand",
 	} {
-		it := specialForms[ours]
-		if specialForms[mals] = it; it == nil {
+		it := me.specialForms[ours]
+		if me.specialForms[mals] = it; it == nil {
 			panic("mixed sth up huh?")
 		}
 	}
 
 	// simple aliases: std funcs
 	for mals, ours := range map[ExprIdent]ExprIdent{
-		"prn":         "print",
 		"read-string": "readExpr",
 		"slurp":       "readTextFile",
 		"load-file":   "loadFile",
@@ -48,14 +43,20 @@ func ensureMALCompatibility() {
 		"vals":        "hashmapVals",
 		"symbol":      "ident",
 	} {
-		it := envMain.Map[ours]
-		if envMain.Map[mals] = it; it == nil {
+		it := me.Env.Map[ours]
+		if (it == nil) && (me.Options.Sandbox != nil) && slices.Contains(sandboxOmitted, ours) {
+			continue
+		} else if me.Env.Map[mals] = it; it == nil {
 			panic("mixed sth up huh?")
 		}
 	}
 
 	// non-alias-able funcs
 	for name, expr := range map[ExprIdent]Expr{
+		"prn": ExprFunc(func(args []Expr) (Expr, error) { // unlike our `print`, with a line break after
+			_, _ = io.WriteString(me.Stdout, str(true, args...)+"\n")
+			return exprNil, nil
+		}),
 		"pr-str": ExprFunc(func(args []Expr) (Expr, error) {
 			var buf strings.Builder
 			for i, arg := range args {
@@ -68,49 +69,26 @@ func ensureMALCompatibility() {
 		}),
 		"with-meta": ExprFunc(func(args []Expr) (Expr, error) {
 			if len(args) > 1 {
-				malMeta[args[0]] = args[1]
+				me.malMeta.Store(args[0], args[1])
 			}
 			return args[0], nil
 		}),
 		"meta": ExprFunc(func(args []Expr) (Expr, error) {
 			if len(args) > 0 {
-				if meta := malMeta[args[0]]; meta != nil {
-					return meta, nil
+				if meta, _ := me.malMeta.Load(args[0]); meta != nil {
+					return meta.(Expr), nil
 				}
 			}
 			return exprNil, nil
 		}),
 	} {
-		envMain.Map[name] = expr
+		me.Env.Map[name] = expr
 	}
 
 	// non-alias-able special-forms
 	for name, sf := range map[ExprIdent]SpecialForm{
-
-		"let*": SpecialForm(func(env *Env, args []Expr) (*Env, Expr, error) {
-			bindings, err := checkIsSeq(args[0])
-			if err != nil {
-				return nil, nil, err
-			}
-			rewritten := make([]Expr, 0, len(bindings)/2)
-			for i := 1; i < len(bindings); i += 2 {
-				rewritten = append(rewritten, ExprList{bindings[i-1], bindings[i]})
-			}
-			return stdLet(env, append([]Expr{(ExprList)(rewritten)}, args[1:]...))
-		}),
-
-		"cond": SpecialForm(func(env *Env, args []Expr) (*Env, Expr, error) { // dont have that as a macro due to github.com/kanaka/mal/issues/655
-			if len(args) == 0 {
-				return nil, exprNil, nil
-			}
-			if (len(args) % 2) != 0 {
-				return nil, nil, fmt.Errorf("expected even number of args to `cond`, not %d", len(args))
-			}
-			the_bool, the_then, the_rest := args[0], args[1], args[2:]
-			call_form := ExprList{ExprIdent("if"), the_bool, the_then, append(ExprList{ExprIdent("cond")}, the_rest...)}
-			return env, call_form, nil
-		}),
-
+		"let*": malLetStar,
+		"cond": malCond,
 		"defmacro!": SpecialForm(func(env *Env, args []Expr) (*Env, Expr, error) {
 			if err := checkArgsCount(2, 2, "`defmacro!`", args); err != nil {
 				return nil, nil, err
@@ -131,15 +109,64 @@ func ensureMALCompatibility() {
 			return stdDef(env, args)
 		}),
 	} {
-		specialForms[name] = sf
+		me.specialForms[name] = sf
 	}
 
 	// the below helper defs
-	if _, err := readAndEval("(" + string(exprIdentDo) + " " + srcMiniStdlibMalCompat + "\n" + string(exprNil) + ")"); err != nil {
+	if _, err := me.ReadAndEval("<srcMiniStdlibMalCompat>", "("+string(exprIdentDo)+" "+srcMiniStdlibMalCompat+"\n"+string(exprNil)+")"); err != nil {
 		panic(err)
 	}
 }
 
+func init() {
+	specialFormRewrites[funcAddr(malLetStar)] = malLetStarRewrite
+	specialFormRewrites[funcAddr(malCond)] = malCondRewrite
+}
+
+func malLetStar(env *Env, args []Expr) (*Env, Expr, error) {
+	rewritten, err := malLetStarRewrite(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stdLet(env, rewritten.(ExprList)[1:])
+}
+
+// malLetStarRewrite turns `(let* (a 1 b 2) body)` into `(let ((a 1) (b 2)) body)`.
+func malLetStarRewrite(args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, -1, "`let*`", args); err != nil {
+		return nil, err
+	}
+	bindings, err := checkIsSeq(args[0])
+	if err != nil {
+		return nil, err
+	}
+	rewritten := make([]Expr, 0, len(bindings)/2)
+	for i := 1; i < len(bindings); i += 2 {
+		rewritten = append(rewritten, ExprList{bindings[i-1], bindings[i]})
+	}
+	return append(ExprList{ExprIdent("let"), (ExprList)(rewritten)}, args[1:]...), nil
+}
+
+func malCond(env *Env, args []Expr) (*Env, Expr, error) { // dont have that as a macro due to github.com/kanaka/mal/issues/655
+	rewritten, err := malCondRewrite(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return env, rewritten, nil
+}
+
+// malCondRewrite turns `(cond a b c d)` into `(if a b (cond c d))`.
+func malCondRewrite(args []Expr) (Expr, error) {
+	if len(args) == 0 {
+		return exprNil, nil
+	}
+	if (len(args) % 2) != 0 {
+		return nil, fmt.Errorf("expected even number of args to `cond`, not %d", len(args))
+	}
+	the_bool, the_then, the_rest := args[0], args[1], args[2:]
+	return ExprList{ExprIdent("if"), the_bool, the_then, append(ExprList{ExprIdent("cond")}, the_rest...)}, nil
+}
+
 const srcMiniStdlibMalCompat = `
 (def *host-language* "go-lisp")
 
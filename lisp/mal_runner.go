@@ -0,0 +1,257 @@
+package lisp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the sections of a mal step test file, as begun by its `;>>> deferrable=True` and `;>>> optional=True` lines
+const (
+	MalTestRequired = iota
+	MalTestDeferrable
+	MalTestOptional
+)
+
+var malTestSectionNames = [3]string{"required", "deferrable", "optional"}
+
+// MalTestResult is the outcome of running one github.com/kanaka/mal step test file (such as `mal/tests/step4_if_fn_do.mal`).
+type MalTestResult struct {
+	File     string
+	Passed   [3]int // per section (`MalTestRequired`, `MalTestDeferrable`, `MalTestOptional`)
+	Total    [3]int
+	Failures []MalTestFailure
+}
+
+// MalTestFailure is one input line of a mal step test file whose result or output did not match its expectation.
+type MalTestFailure struct {
+	Line     int
+	Section  int
+	Soft     bool // after a `;>>> soft=True` line, so not to fail a mal implementation (with runtest.py) either
+	Input    string
+	Expected string // the `;/` output regexps, each on its own line, then the `;=>` result, if any
+	Actual   string // the output, then the result (or the error)
+}
+
+// Percent returns the pass rate of the `section` (such as `MalTestRequired`), or 100 if it has no tests.
+func (me *MalTestResult) Percent(section int) float64 {
+	if me.Total[section] == 0 {
+		return 100
+	}
+	return 100 * float64(me.Passed[section]) / float64(me.Total[section])
+}
+
+// malTestCase is one input line with its expectations: the result as printed (unless `nil`), preceded by the output matching `out`.
+// without either, the input is only there to set up the tests following it, so is evaluated but not checked.
+type malTestCase struct {
+	line    int
+	section int
+	soft    bool
+	input   string
+	out     []string
+	ret     *string
+}
+
+// RunMalTestFile runs the mal step test file at `filePath`, like the runtest.py of github.com/kanaka/mal: it feeds each
+// input line to a new `Interpreter` (with `options`, plus `MalCompat`) as if entered in its `Repl`, and compares the printed
+// result, and the output (of `print`s and errors) before it, to the `;=>` and `;/` expectation lines following the input.
+// as in those steps of mal, the inputs of the step 0 and step 1 files are only read and printed, not evaluated. as with
+// runtest.py, relative file paths in the inputs (such as in `load-file`) are relative to the current directory.
+func RunMalTestFile(options Options, filePath string) (*MalTestResult, error) {
+	cases, err := readMalTestCases(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var output strings.Builder
+	options.MalCompat = true
+	interp := NewInterpreter(options)
+	interp.Stdin, interp.Stdout, interp.Stderr = strings.NewReader(""), &output, &output
+	ret := &MalTestResult{File: filePath}
+	read_only := strings.HasPrefix(filepath.Base(filePath), "step0") || strings.HasPrefix(filepath.Base(filePath), "step1")
+	for _, test := range cases {
+		output.Reset()
+		result := malTestEval(interp, test.input, !read_only)
+		if (test.ret == nil) && (len(test.out) == 0) { // just setting up the tests following it
+			continue
+		}
+		actual, out_pattern := strings.TrimSuffix(output.String(), "\n"), "(?s)^"+strings.Join(test.out, "\n")
+		if test.ret == nil { // the `;/` lines then need to match only the start of all the lines printed, the result included
+			actual, out_pattern = strings.TrimPrefix(actual+"\n"+result, "\n"), out_pattern+"(\n.*)?"
+		}
+		out_regexp, err := regexp.Compile(out_pattern + "$")
+		passed := (err == nil) && out_regexp.MatchString(actual) && ((test.ret == nil) || (result == *test.ret))
+		if test.ret != nil {
+			actual = strings.TrimPrefix(actual+"\n"+result, "\n")
+		}
+
+		ret.Total[test.section]++
+		if passed {
+			ret.Passed[test.section]++
+			continue
+		}
+		expected := strings.Join(test.out, "\n")
+		if test.ret != nil {
+			expected = strings.TrimPrefix(expected+"\n"+*test.ret, "\n")
+		}
+		if err != nil {
+			expected += "\n(not supported by Go's regexp package: " + err.Error() + ")"
+		}
+		ret.Failures = append(ret.Failures, MalTestFailure{Line: test.line, Section: test.section, Soft: test.soft,
+			Input: test.input, Expected: expected, Actual: actual})
+	}
+	return ret, nil
+}
+
+// malTestEval reads (and if `evaluate`, evaluates) all forms in `input` and returns the last one as printed, or the first error
+// as "Error: " and its message.
+func malTestEval(interp *Interpreter, input string, evaluate bool) (ret string) {
+	defer func() { // a panic is a failed test, not a failed test run
+		if err := recover(); err != nil {
+			ret = fmt.Sprintf("Error: panic: %v", err)
+		}
+	}()
+	forms := newFormReader("<input>", strings.NewReader(input))
+	for {
+		form, err := forms.ReadForm()
+		if err == io.EOF {
+			return
+		} else if (err == nil) && evaluate {
+			form, err = interp.Eval(form)
+		}
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		ret = str(true, malTestPrintable(form))
+	}
+}
+
+// malTestPrintable returns `expr` with all `:true`, `:false` and `:nil` (in any lists, vectors and hashmaps too) turned into
+// the identifiers `true`, `false` and `nil`, so that it prints as expected by mal.
+func malTestPrintable(expr Expr) Expr {
+	switch it := expr.(type) {
+	case ExprKeyword:
+		if (it == exprTrue) || (it == exprFalse) || (it == exprNil) {
+			return ExprIdent(it[1:])
+		}
+	case ExprList:
+		ret := make(ExprList, len(it))
+		for i, item := range it {
+			ret[i] = malTestPrintable(item)
+		}
+		return ret
+	case ExprVec:
+		ret := make(ExprVec, len(it))
+		for i, item := range it {
+			ret[i] = malTestPrintable(item)
+		}
+		return ret
+	case ExprHashMap:
+		ret := make(ExprHashMap, len(it))
+		for key, value := range it {
+			ret[key] = malTestPrintable(value)
+		}
+		return ret
+	}
+	return expr
+}
+
+// readMalTestCases parses the mal step test file at `filePath` into its inputs, each with the expectation lines following it (if any).
+func readMalTestCases(filePath string) (ret []malTestCase, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	section, soft, expecting := MalTestRequired, false, false // `expecting` the expectation lines of the last input
+	var line_num int
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		line_num++
+		line := strings.TrimSuffix(lines.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, ";>>> "):
+			for _, setting := range strings.Fields(line[len(";>>> "):]) {
+				switch setting {
+				case "deferrable=True":
+					section = MalTestDeferrable
+				case "optional=True":
+					section = MalTestOptional
+				case "soft=True":
+					soft = true
+				}
+			}
+		case strings.HasPrefix(line, ";/"), strings.HasPrefix(line, ";=>"):
+			if !expecting { // such as after a commented-out input
+				continue
+			}
+			test := &ret[len(ret)-1]
+			if result, is_ret := strings.CutPrefix(line, ";=>"); is_ret {
+				test.ret, expecting = &result, false
+			} else {
+				test.out = append(test.out, line[len(";/"):])
+			}
+		case strings.HasPrefix(line, ";"), strings.TrimSpace(line) == "":
+			expecting = false
+		default:
+			ret, expecting = append(ret, malTestCase{line: line_num, section: section, soft: soft, input: line}), true
+		}
+	}
+	if err = lines.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// RunMalTests runs, via `RunMalTestFile`, the `step*.mal` files in `paths` (directories, or else files), writing for each a line
+// of its pass rates per section to `w`, and before it (if `verbose`) its failures. it returns all results (even after a failed file).
+func RunMalTests(options Options, w io.Writer, verbose bool, paths ...string) (results []*MalTestResult, err error) {
+	var file_paths []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			file_paths = append(file_paths, path)
+			continue
+		}
+		step_files, _ := filepath.Glob(filepath.Join(path, "step*.mal")) // sorted
+		file_paths = append(file_paths, step_files...)
+	}
+
+	var all MalTestResult // the totals
+	for _, file_path := range file_paths {
+		result, err := RunMalTestFile(options, file_path)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+		if verbose {
+			for _, failure := range result.Failures {
+				soft := ""
+				if failure.Soft {
+					soft = ", soft"
+				}
+				fmt.Fprintf(w, "--- FAIL: %s:%d (%s%s)\n    %s\n", file_path, failure.Line, malTestSectionNames[failure.Section], soft, failure.Input)
+				fmt.Fprintf(w, "        expected: %s\n          actual: %s\n", strings.ReplaceAll(failure.Expected, "\n", "\n                  "),
+					strings.ReplaceAll(failure.Actual, "\n", "\n                  "))
+			}
+		}
+		fmt.Fprintf(w, "%-24s %s\n", filepath.Base(file_path), result.rates())
+		for i := range all.Total {
+			all.Passed[i], all.Total[i] = all.Passed[i]+result.Passed[i], all.Total[i]+result.Total[i]
+		}
+	}
+	fmt.Fprintf(w, "%-24s %s\n", "total", all.rates())
+	return results, nil
+}
+
+func (me *MalTestResult) rates() string {
+	var buf strings.Builder
+	for i, name := range malTestSectionNames {
+		fmt.Fprintf(&buf, "  %s %4d/%-4d %5.1f%%", name, me.Passed[i], me.Total[i], me.Percent(i))
+	}
+	return buf.String()
+}
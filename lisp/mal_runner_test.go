@@ -0,0 +1,58 @@
+package lisp
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// malTestBaseline has the numbers of passing tests (per section, as in `MalTestResult.Passed`) of each mal step test file, as of
+// the last change to improve on them: TestMalSteps fails for any fewer, and logs any more for them to be raised here.
+var malTestBaseline = map[string][3]int{
+	"step0_repl.mal":       {12, 0, 5},
+	"step1_read_print.mal": {24, 58, 18},
+	"step2_eval.mal":       {8, 2, 0},
+	"step3_env.mal":        {23, 3, 1},
+	"step4_if_fn_do.mal":   {79, 89, 0},
+	"step5_tco.mal":        {4, 0, 0},
+	"step6_file.mal":       {26, 9, 17},
+	"step7_quote.mal":      {55, 8, 45},
+	"step8_macros.mal":     {14, 30, 2},
+	"step9_try.mal":        {27, 63, 17},
+	"stepA_mal.mal":        {1, 5, 47},
+}
+
+// TestMalSteps runs the mal step test files in `../mal/tests`, both with and without `Options.Bytecode`, and logs their pass rates
+// (with `-v`, also all their failures). it fails for any fewer passing than in `malTestBaseline`. the failures in their required sections are all for known differences to mal, the same in either:
+//   - step0: its inputs are echoed by mal without reading them, but are read (and so, for most, fail to read) here
+//   - step6: `nil` is the keyword `:nil` (as bound to the ident `nil`), so does not equal the ident `nil` as read by `read-string`
+//   - step6: an atom prints as its `(atomFrom 2)` constructor call, not as `(atom 2)`
+//   - step7: a hashmap prints as `{ "a" b }`, not as `{"a" b}`
+//   - step9: the error of an undefined ident is `undefined: abc`, not `'abc' not found`
+//   - step9: `nth` (an alias of `at`) returns `nil` for an index out of range, instead of failing
+//   - stepA: the test runner feeds no input to `readline`, the line after it being expected as that input
+func TestMalSteps(t *testing.T) {
+	t.Chdir("../mal/tests") // for their `load-file`s and `slurp`s
+	for _, bytecode := range []bool{false, true} {
+		var report strings.Builder
+		results, err := RunMalTests(Options{Bytecode: bytecode}, &report, testing.Verbose(), ".")
+		t.Logf("bytecode=%v:\n%s", bytecode, report.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range results {
+			baseline, ok := malTestBaseline[filepath.Base(result.File)]
+			if !ok {
+				t.Errorf("bytecode=%v: %s: not in malTestBaseline", bytecode, result.File)
+				continue
+			}
+			for section, name := range malTestSectionNames {
+				if passed := result.Passed[section]; passed < baseline[section] {
+					t.Errorf("bytecode=%v: %s: only %d of the %s tests passed, not %d as before", bytecode, result.File, passed, name, baseline[section])
+				} else if passed > baseline[section] {
+					t.Logf("bytecode=%v: %s: %d of the %s tests passed, more than the %d of malTestBaseline", bytecode, result.File, passed, name, baseline[section])
+				}
+			}
+		}
+	}
+}
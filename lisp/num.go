@@ -0,0 +1,308 @@
+package lisp
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the numeric tower, from lowest to highest rank: `ExprNum` (machine ints) and `*ExprBigInt` (arbitrary-precision ints)
+// are both integers, `*ExprRat` (exact fractions) and `ExprFloat` are not. mixed-type operations promote the lower-ranked
+// operand, while exact results are always normalized down to the lowest rank that holds them exactly: so a `*ExprBigInt`
+// is never within `int` range, and a `*ExprRat` never has a denominator of 1. `*ExprBigInt`s and `*ExprRat`s are never
+// mutated after construction.
+
+type ExprFloat float64
+type ExprBigInt big.Int
+type ExprRat big.Rat
+
+func (ExprFloat) isExpr()   {}
+func (*ExprBigInt) isExpr() {}
+func (*ExprRat) isExpr()    {}
+
+var (
+	errDivByZero = errors.New("division by zero")
+
+	regexNumInt   = regexp.MustCompile(`^-?[0-9]+$`)
+	regexNumRat   = regexp.MustCompile(`^-?[0-9]+/[0-9]+$`)
+	regexNumFloat = regexp.MustCompile(`^-?([0-9]+\.[0-9]*|\.[0-9]+|[0-9]+(\.[0-9]*)?[eE][-+]?[0-9]+|\.[0-9]+[eE][-+]?[0-9]+)$`)
+)
+
+const (
+	numRankNone = iota
+	numRankInt
+	numRankBigInt
+	numRankRat
+	numRankFloat
+)
+
+func numRank(expr Expr) int {
+	switch expr.(type) {
+	case ExprNum:
+		return numRankInt
+	case *ExprBigInt:
+		return numRankBigInt
+	case *ExprRat:
+		return numRankRat
+	case ExprFloat:
+		return numRankFloat
+	}
+	return numRankNone
+}
+
+func isNum(expr Expr) bool {
+	return numRank(expr) != numRankNone
+}
+
+// readNum parses `tok` if it is a number literal, else returns `nil, nil`.
+func readNum(tok string) (Expr, error) {
+	if regexNumInt.MatchString(tok) {
+		if num, err := strconv.Atoi(tok); err == nil {
+			return ExprNum(num), nil
+		}
+		big_int, _ := new(big.Int).SetString(tok, 10)
+		return exprBigInt(big_int), nil
+	} else if regexNumRat.MatchString(tok) {
+		rat, ok := new(big.Rat).SetString(tok)
+		if !ok {
+			return nil, errDivByZero
+		}
+		return exprRat(rat), nil
+	} else if regexNumFloat.MatchString(tok) {
+		num, err := strconv.ParseFloat(tok, 64)
+		return ExprFloat(num), err
+	}
+	return nil, nil
+}
+
+func exprBigInt(it *big.Int) Expr {
+	if it.IsInt64() && (int64(int(it.Int64())) == it.Int64()) {
+		return ExprNum(it.Int64())
+	}
+	return (*ExprBigInt)(it)
+}
+
+func exprRat(it *big.Rat) Expr {
+	if it.IsInt() {
+		return exprBigInt(new(big.Int).Set(it.Num()))
+	}
+	return (*ExprRat)(it)
+}
+
+func numToBigInt(expr Expr) *big.Int {
+	switch it := expr.(type) {
+	case ExprNum:
+		return big.NewInt(int64(it))
+	case *ExprBigInt:
+		return (*big.Int)(it)
+	}
+	panic(expr)
+}
+
+func numToRat(expr Expr) *big.Rat {
+	switch it := expr.(type) {
+	case ExprNum, *ExprBigInt:
+		return new(big.Rat).SetInt(numToBigInt(it))
+	case *ExprRat:
+		return (*big.Rat)(it)
+	}
+	panic(expr)
+}
+
+func numToFloat(expr Expr) float64 {
+	switch it := expr.(type) {
+	case ExprNum:
+		return float64(it)
+	case *ExprBigInt:
+		ret, _ := new(big.Float).SetInt((*big.Int)(it)).Float64()
+		return ret
+	case *ExprRat:
+		ret, _ := (*big.Rat)(it).Float64()
+		return ret
+	case ExprFloat:
+		return float64(it)
+	}
+	panic(expr)
+}
+
+func checkIsNum(expr Expr) (Expr, error) {
+	if !isNum(expr) {
+		return nil, fmt.Errorf("expected a number, not `%s`", str(true, expr))
+	}
+	return expr, nil
+}
+
+// checkAreNums checks that both operands are numbers, and returns the rank both need promoting to.
+func checkAreNums(op1 Expr, op2 Expr) (int, error) {
+	if _, err := checkIsNum(op1); err != nil {
+		return 0, err
+	} else if _, err = checkIsNum(op2); err != nil {
+		return 0, err
+	}
+	return max(numRank(op1), numRank(op2)), nil
+}
+
+// numFold applies `op` from left to right over `acc` and all `args`.
+func numFold(acc Expr, args []Expr, op func(Expr, Expr) (Expr, error)) (_ Expr, err error) {
+	for _, arg := range args {
+		if acc, err = op(acc, arg); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+func numAdd(op1 Expr, op2 Expr) (Expr, error) {
+	rank, err := checkAreNums(op1, op2)
+	if err != nil {
+		return nil, err
+	}
+	switch rank {
+	case numRankInt:
+		a, b := op1.(ExprNum), op2.(ExprNum)
+		if ret := a + b; (ret > a) == (b > 0) {
+			return ret, nil
+		}
+		return exprBigInt(new(big.Int).Add(numToBigInt(a), numToBigInt(b))), nil
+	case numRankBigInt:
+		return exprBigInt(new(big.Int).Add(numToBigInt(op1), numToBigInt(op2))), nil
+	case numRankRat:
+		return exprRat(new(big.Rat).Add(numToRat(op1), numToRat(op2))), nil
+	}
+	return ExprFloat(numToFloat(op1) + numToFloat(op2)), nil
+}
+
+func numSub(op1 Expr, op2 Expr) (Expr, error) {
+	rank, err := checkAreNums(op1, op2)
+	if err != nil {
+		return nil, err
+	}
+	switch rank {
+	case numRankInt:
+		a, b := op1.(ExprNum), op2.(ExprNum)
+		if ret := a - b; (ret < a) == (b > 0) {
+			return ret, nil
+		}
+		return exprBigInt(new(big.Int).Sub(numToBigInt(a), numToBigInt(b))), nil
+	case numRankBigInt:
+		return exprBigInt(new(big.Int).Sub(numToBigInt(op1), numToBigInt(op2))), nil
+	case numRankRat:
+		return exprRat(new(big.Rat).Sub(numToRat(op1), numToRat(op2))), nil
+	}
+	return ExprFloat(numToFloat(op1) - numToFloat(op2)), nil
+}
+
+func numMul(op1 Expr, op2 Expr) (Expr, error) {
+	rank, err := checkAreNums(op1, op2)
+	if err != nil {
+		return nil, err
+	}
+	switch rank {
+	case numRankInt:
+		a, b := op1.(ExprNum), op2.(ExprNum)
+		if ret := a * b; (a == 0) || ((ret/a == b) && !((a == -1) && (b == math.MinInt))) {
+			return ret, nil
+		}
+		return exprBigInt(new(big.Int).Mul(numToBigInt(a), numToBigInt(b))), nil
+	case numRankBigInt:
+		return exprBigInt(new(big.Int).Mul(numToBigInt(op1), numToBigInt(op2))), nil
+	case numRankRat:
+		return exprRat(new(big.Rat).Mul(numToRat(op1), numToRat(op2))), nil
+	}
+	return ExprFloat(numToFloat(op1) * numToFloat(op2)), nil
+}
+
+// numDiv divides exactly unless a float is involved: integers that don't divide evenly make a `*ExprRat`.
+func numDiv(op1 Expr, op2 Expr) (Expr, error) {
+	rank, err := checkAreNums(op1, op2)
+	if err != nil {
+		return nil, err
+	}
+	switch rank {
+	case numRankInt:
+		a, b := op1.(ExprNum), op2.(ExprNum)
+		if b == 0 {
+			return nil, errDivByZero
+		} else if ((a % b) == 0) && !((a == math.MinInt) && (b == -1)) {
+			return a / b, nil
+		}
+	case numRankFloat:
+		return ExprFloat(numToFloat(op1) / numToFloat(op2)), nil
+	}
+	divisor := numToRat(op2)
+	if divisor.Sign() == 0 {
+		return nil, errDivByZero
+	}
+	return exprRat(new(big.Rat).Quo(numToRat(op1), divisor)), nil
+}
+
+func numCompare(op1 Expr, op2 Expr) (int, error) {
+	rank, err := checkAreNums(op1, op2)
+	if err != nil {
+		return 0, err
+	}
+	switch rank {
+	case numRankInt:
+		return cmp.Compare(op1.(ExprNum), op2.(ExprNum)), nil
+	case numRankBigInt:
+		return numToBigInt(op1).Cmp(numToBigInt(op2)), nil
+	case numRankRat:
+		return numToRat(op1).Cmp(numToRat(op2)), nil
+	}
+	return cmp.Compare(numToFloat(op1), numToFloat(op2)), nil
+}
+
+func numWriteTo(w Writer, expr Expr) {
+	switch it := expr.(type) {
+	case ExprNum:
+		w.WriteString(strconv.Itoa(int(it)))
+	case *ExprBigInt:
+		w.WriteString((*big.Int)(it).String())
+	case *ExprRat:
+		w.WriteString((*big.Rat)(it).String())
+	case ExprFloat:
+		s := strconv.FormatFloat(float64(it), 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") { // so that it reads back in as a float, not an int
+			s += ".0"
+		}
+		w.WriteString(s)
+	}
+}
+
+func stdFloat(args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 1, "`float`", args); err != nil {
+		return nil, err
+	}
+	num, err := checkIsNum(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return ExprFloat(numToFloat(num)), nil
+}
+
+// stdInt truncates towards zero.
+func stdInt(args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 1, "`int`", args); err != nil {
+		return nil, err
+	}
+	num, err := checkIsNum(args[0])
+	if err != nil {
+		return nil, err
+	}
+	switch it := num.(type) {
+	case *ExprRat:
+		return exprBigInt(new(big.Int).Quo((*big.Rat)(it).Num(), (*big.Rat)(it).Denom())), nil
+	case ExprFloat:
+		if math.IsNaN(float64(it)) || math.IsInf(float64(it), 0) {
+			return nil, fmt.Errorf("cannot convert `%s` to an integer", str(true, it))
+		}
+		big_int, _ := big.NewFloat(math.Trunc(float64(it))).Int(nil)
+		return exprBigInt(big_int), nil
+	}
+	return num, nil
+}
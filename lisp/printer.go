@@ -1,4 +1,4 @@
-package main
+package lisp
 
 import (
 	"fmt"
@@ -47,8 +47,8 @@ func exprWriteTo(w Writer, expr Expr, srcLike bool) {
 		} else {
 			w.WriteString(string(it))
 		}
-	case ExprNum:
-		w.WriteString(strconv.Itoa(int(it)))
+	case ExprNum, *ExprBigInt, *ExprRat, ExprFloat:
+		numWriteTo(w, it)
 	case ExprErr:
 		if srcLike {
 			w.WriteString("(error ")
@@ -60,11 +60,17 @@ func exprWriteTo(w Writer, expr Expr, srcLike bool) {
 	case *ExprAtom:
 		if srcLike {
 			w.WriteString("(atomFrom ")
-			exprWriteTo(w, it.Ref, true)
+			exprWriteTo(w, it.Get(), true)
 			w.WriteByte(')')
 		} else {
-			exprWriteTo(w, it.Ref, false)
+			exprWriteTo(w, it.Get(), false)
 		}
+	case ExprGo:
+		goValueWriteTo(w, it, srcLike)
+	case *ExprPromise:
+		promiseWriteTo(w, it, srcLike)
+	case ExprChan:
+		w.WriteString(fmt.Sprintf("<chan %d/%d>", len(it), cap(it)))
 	default:
 		w.WriteString(fmt.Sprintf("%#v", it))
 	}
@@ -0,0 +1,341 @@
+package lisp
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the innermost calls of a call stack that `WritePprof` writes, as deep recursions would otherwise make for huge profiles
+const profMaxStackDepth = 256
+
+// Profiler records, per `*ExprFn` (by name and definition site), the number of calls and the inclusive and exclusive time
+// spent in them, for `WriteTable` and `WritePprof`. a tail call ends the call it replaces (as far as the profile is concerned),
+// and calls made by Go builtins such as `apply` (and so all calls with `Options.NoTco`) count as made from the top level,
+// with their times not subtracted from the exclusive times of their actual callers. with calls from several goroutines,
+// their times each count in full, so that their sums can go over the wall time profiled.
+type Profiler struct {
+	on      atomic.Bool // so that the common not-profiling case needs no locking
+	mutex   sync.Mutex  // for all the below
+	fns     map[string]*profFn
+	byCode  map[profCodeKey]*profFn
+	root    profStack // its `fn` is `nil`
+	started time.Time // of the current `Start`, if on
+	elapsed time.Duration
+}
+
+// profFn is one row of the profile table: all calls of all `*ExprFn`s of the same name and definition site.
+type profFn struct {
+	id        uint64 // 1-based
+	name      string
+	site      *SrcSpan
+	calls     int64
+	inclusive time.Duration // not counting the recursive calls within a call
+	exclusive time.Duration
+	active    int // calls in progress, for `inclusive`
+}
+
+type profCodeKey struct {
+	code *fnCode
+	name string
+}
+
+// profStack is a node in the tree of all the call stacks seen, for `WritePprof`.
+type profStack struct {
+	fn        *profFn
+	children  map[*profFn]*profStack
+	calls     int64
+	exclusive time.Duration
+}
+
+// profFrame is a call in progress.
+type profFrame struct {
+	parent   *profFrame // `nil` for calls from the top level
+	stack    *profStack
+	start    time.Time
+	children time.Duration // the inclusive times of the calls made from this one, all of which ended already
+	done     bool
+}
+
+// Start begins (or continues) recording calls.
+func (me *Profiler) Start() {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if me.fns == nil {
+		me.fns, me.byCode = map[string]*profFn{}, map[profCodeKey]*profFn{}
+	}
+	if !me.on.Swap(true) {
+		me.started = time.Now()
+	}
+}
+
+// Stop pauses recording calls (though calls in progress still get recorded once they end).
+func (me *Profiler) Stop() {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if me.on.Swap(false) {
+		me.elapsed += time.Since(me.started)
+	}
+}
+
+// call records the call of `fn` (by `name`) from `callerEnv`, or if `tailOf` is not `nil`, in place of that call (which it ends).
+// it returns the new call for `ret` (and for the `Env` of `fn`'s body), or `nil` if not profiling.
+func (me *Profiler) call(fn *ExprFn, name string, callerEnv *Env, tailOf *profFrame) *profFrame {
+	now := time.Now()
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	var parent *profFrame
+	if tailOf != nil {
+		parent = tailOf.parent
+		me.end(tailOf, now)
+	} else if callerEnv != nil {
+		parent = callerEnv.profFrame()
+	}
+	if !me.on.Load() {
+		return nil
+	}
+
+	key := profCodeKey{code: fn.code, name: name}
+	prof_fn := me.byCode[key]
+	if prof_fn == nil {
+		site := srcSpanOf(ExprList(fn.params))
+		if site == nil {
+			site = srcSpanOf(fn.body)
+		}
+		row_key := name + "\x00"
+		if site != nil {
+			row_key += site.String()
+		}
+		if prof_fn = me.fns[row_key]; prof_fn == nil {
+			prof_fn = &profFn{id: uint64(len(me.fns) + 1), name: name, site: site}
+			me.fns[row_key] = prof_fn
+		}
+		me.byCode[key] = prof_fn
+	}
+	prof_fn.calls++
+	prof_fn.active++
+
+	stack := &me.root
+	if parent != nil {
+		stack = parent.stack
+	}
+	child := stack.children[prof_fn]
+	if child == nil {
+		if stack.children == nil {
+			stack.children = map[*profFn]*profStack{}
+		}
+		child = &profStack{fn: prof_fn}
+		stack.children[prof_fn] = child
+	}
+	return &profFrame{parent: parent, stack: child, start: now}
+}
+
+// ret records the end of `frame`, if not `nil`.
+func (me *Profiler) ret(frame *profFrame) {
+	if frame == nil {
+		return
+	}
+	now := time.Now()
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.end(frame, now)
+}
+
+func (me *Profiler) end(frame *profFrame, now time.Time) {
+	frame.done = true
+	took := now.Sub(frame.start)
+	exclusive := max(0, took-frame.children)
+	if frame.parent != nil {
+		frame.parent.children += took
+	}
+	prof_fn := frame.stack.fn
+	if prof_fn.active--; prof_fn.active == 0 {
+		prof_fn.inclusive += took
+	}
+	prof_fn.exclusive += exclusive
+	frame.stack.calls++
+	frame.stack.exclusive += exclusive
+}
+
+// profFrame returns the call in progress that evaluations in `me` happen in, if any (and if it was recorded).
+func (me *Env) profFrame() *profFrame {
+	for env := me; env != nil; env = env.Parent {
+		if env.prof != nil {
+			if env.prof.done { // `me` is the env of some closure made in that call, which is then being called after the profile began
+				return nil
+			}
+			return env.prof
+		}
+	}
+	return nil
+}
+
+// total returns the wall time profiled so far. it is to be called with `me.mutex` locked.
+func (me *Profiler) total() time.Duration {
+	if me.on.Load() {
+		return me.elapsed + time.Since(me.started)
+	}
+	return me.elapsed
+}
+
+// WriteTable writes the profile as a table, with one row per function sorted by exclusive time (and the percentages of the wall time profiled).
+func (me *Profiler) WriteTable(w io.Writer) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	rows := make([]*profFn, 0, len(me.fns))
+	for _, prof_fn := range me.fns {
+		rows = append(rows, prof_fn)
+	}
+	slices.SortFunc(rows, func(a *profFn, b *profFn) int {
+		return cmp.Or(cmp.Compare(b.exclusive, a.exclusive), cmp.Compare(b.inclusive, a.inclusive), cmp.Compare(b.calls, a.calls), cmp.Compare(a.id, b.id))
+	})
+
+	total := me.total()
+	percent := func(took time.Duration) float64 {
+		if total <= 0 {
+			return 0
+		}
+		return 100 * float64(took) / float64(total)
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%12s %7s %12s %7s %10s  %s\n", "exclusive", "%", "inclusive", "%", "calls", "function (profiled "+total.Round(time.Microsecond).String()+")")
+	for _, row := range rows {
+		fmt.Fprintf(&buf, "%12s %6.2f%% %12s %6.2f%% %10d  %s\n", row.exclusive.Round(time.Microsecond), percent(row.exclusive),
+			row.inclusive.Round(time.Microsecond), percent(row.inclusive), row.calls, row.label())
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+func (me *profFn) label() string {
+	name := cmp.Or(me.name, "<anonymous fn>")
+	if me.site != nil {
+		name += " (" + me.site.String() + ")"
+	}
+	return name
+}
+
+// WritePprof writes the profile in the (gzipped protobuf) format of github.com/google/pprof, for `go tool pprof`:
+// its frames are the functions in `WriteTable`, and each call stack seen is a sample with the number of calls that
+// ended in it and their exclusive time.
+func (me *Profiler) WritePprof(w io.Writer) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	strs := map[string]uint64{"": 0}
+	str_table := []string{""}
+	str_idx := func(s string) uint64 {
+		idx, exists := strs[s]
+		if !exists {
+			idx = uint64(len(str_table))
+			strs[s], str_table = idx, append(str_table, s)
+		}
+		return idx
+	}
+	value_type := func(typ string, unit string) func(*protoBuf) {
+		return func(msg *protoBuf) {
+			msg.varint(1, str_idx(typ))
+			msg.varint(2, str_idx(unit))
+		}
+	}
+
+	var profile protoBuf
+	profile.msg(1, value_type("calls", "count"))
+	profile.msg(1, value_type("time", "nanoseconds"))
+	var path []uint64 // the `profFn` ids of the call stack, outermost first
+	var sample func(*profStack)
+	sample = func(stack *profStack) {
+		if stack.fn != nil {
+			path = append(path, stack.fn.id)
+			defer func() { path = path[:len(path)-1] }()
+		}
+		if stack.calls > 0 {
+			locs := slices.Clone(path[max(0, len(path)-profMaxStackDepth):])
+			slices.Reverse(locs) // innermost first
+			profile.msg(2, func(msg *protoBuf) {
+				msg.packed(1, locs)
+				msg.packed(2, []uint64{uint64(stack.calls), uint64(stack.exclusive)})
+			})
+		}
+		for _, child := range stack.children {
+			sample(child)
+		}
+	}
+	sample(&me.root)
+	for _, prof_fn := range me.fns {
+		var file string
+		var line uint64
+		if prof_fn.site != nil {
+			file, line = prof_fn.site.File, uint64(prof_fn.site.Line)
+		}
+		profile.msg(4, func(msg *protoBuf) { // the `Location`
+			msg.varint(1, prof_fn.id)
+			msg.msg(4, func(msg *protoBuf) {
+				msg.varint(1, prof_fn.id)
+				msg.varint(2, line)
+			})
+		})
+		profile.msg(5, func(msg *protoBuf) { // the `Function`
+			msg.varint(1, prof_fn.id)
+			msg.varint(2, str_idx(prof_fn.label()))
+			msg.varint(3, str_idx(cmp.Or(prof_fn.name, "<anonymous fn>")))
+			msg.varint(4, str_idx(file))
+			msg.varint(5, line)
+		})
+	}
+	period_type := value_type("time", "nanoseconds")
+	total := me.total()
+	for _, s := range str_table {
+		profile.bytes(6, []byte(s))
+	}
+	profile.varint(9, uint64(time.Now().Add(-total).UnixNano()))
+	profile.varint(10, uint64(total))
+	profile.msg(11, period_type)
+	profile.varint(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// protoBuf is an encoded protobuf message, appended to field by field.
+type protoBuf []byte
+
+func (me *protoBuf) tag(field int, wireType byte) {
+	*me = binary.AppendUvarint(*me, (uint64(field)<<3)|uint64(wireType))
+}
+
+func (me *protoBuf) varint(field int, value uint64) {
+	if value != 0 {
+		me.tag(field, 0)
+		*me = binary.AppendUvarint(*me, value)
+	}
+}
+
+func (me *protoBuf) bytes(field int, value []byte) {
+	me.tag(field, 2)
+	*me = binary.AppendUvarint(*me, uint64(len(value)))
+	*me = append(*me, value...)
+}
+
+func (me *protoBuf) packed(field int, values []uint64) {
+	var buf []byte
+	for _, value := range values {
+		buf = binary.AppendUvarint(buf, value)
+	}
+	me.bytes(field, buf)
+}
+
+func (me *protoBuf) msg(field int, fill func(*protoBuf)) {
+	var msg protoBuf
+	fill(&msg)
+	me.bytes(field, msg)
+}
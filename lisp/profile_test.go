@@ -0,0 +1,207 @@
+package lisp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const srcTestProfile = `
+(def leaf (fn () (sleepMs 40)))
+(def mid (fn (n) (if (= n 0) (leaf) (do (sleepMs 20) (mid (- n 1)) :done))))
+(def top (fn () (do (mid 2) (leaf))))
+`
+
+// testProfile profiles `(top)` of `srcTestProfile`, in which `mid` sleeps for 40ms (and recurses twice), and `leaf` sleeps for 80ms.
+// both calls of `leaf` are tail calls, so replace (as far as the profile is concerned) the `top` and `mid` calls making them.
+func testProfile(t *testing.T) *Interpreter {
+	interp := NewInterpreter(Options{})
+	if err := interp.Env.Bind("sleepMs", func(ms int) { time.Sleep(time.Duration(ms) * time.Millisecond) }); err != nil {
+		t.Fatal(err)
+	} else if err = interp.Load("prof.lisp", strings.NewReader(srcTestProfile)); err != nil {
+		t.Fatal(err)
+	}
+	interp.Profiler.Start()
+	if _, err := interp.ReadAndEval("", "(top)"); err != nil {
+		t.Fatal(err)
+	}
+	interp.Profiler.Stop()
+	return interp
+}
+
+func TestProfilerWriteTable(t *testing.T) {
+	var buf strings.Builder
+	if err := testProfile(t).Profiler.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 rows, got:\n%s", buf.String())
+	}
+	type row struct {
+		exclusive, inclusive time.Duration
+		calls                string
+	}
+	rows := map[string]row{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		exclusive, err1 := time.ParseDuration(fields[0])
+		inclusive, err2 := time.ParseDuration(fields[2])
+		if (err1 != nil) || (err2 != nil) {
+			t.Fatalf("unexpected row: %s", line)
+		}
+		rows[strings.Join(fields[5:], " ")] = row{exclusive: exclusive, inclusive: inclusive, calls: fields[4]}
+	}
+	const ms = time.Millisecond
+	for label, want := range map[string]struct {
+		calls                               string
+		minExclusive, maxExclusive, minIncl time.Duration
+	}{
+		"leaf (prof.lisp:2:18)": {calls: "2", minExclusive: 80 * ms, maxExclusive: 120 * ms, minIncl: 80 * ms},
+		"mid (prof.lisp:3:14)":  {calls: "3", minExclusive: 40 * ms, maxExclusive: 80 * ms, minIncl: 80 * ms}, // without the 40ms of `leaf`
+		"top (prof.lisp:4:17)":  {calls: "1", minExclusive: 0, maxExclusive: 40 * ms, minIncl: 80 * ms},
+	} {
+		have, ok := rows[label]
+		if !ok {
+			t.Errorf("no row for %s in:\n%s", label, buf.String())
+		} else if (have.calls != want.calls) || (have.exclusive < want.minExclusive) || (have.exclusive >= want.maxExclusive) ||
+			(have.inclusive < want.minIncl) || (have.inclusive < have.exclusive) {
+			t.Errorf("unexpected row for %s: %+v", label, have)
+		}
+	}
+	// the recursive calls of `mid` within the outermost one are not counted again: else it'd be at least 80 + 60 ms
+	if mid := rows["mid (prof.lisp:3:14)"]; mid.inclusive >= 120*ms {
+		t.Errorf("expected the inclusive time of mid to not count its recursive calls, got %s", mid.inclusive)
+	}
+}
+
+// testProtoFields decodes the protobuf message `buf` into its fields, by number: varints as `uint64`s, all else as `[]byte`s.
+func testProtoFields(t *testing.T, buf []byte) map[uint64][]any {
+	ret := map[uint64][]any{}
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+		if n <= 0 {
+			t.Fatalf("invalid protobuf tag at %d bytes before the end", len(buf))
+		}
+		buf = buf[n:]
+		switch field, wire_type := tag>>3, tag&7; wire_type {
+		case 0:
+			value, n := binary.Uvarint(buf)
+			if n <= 0 {
+				t.Fatalf("invalid varint in field %d", field)
+			}
+			ret[field], buf = append(ret[field], value), buf[n:]
+		case 2:
+			size, n := binary.Uvarint(buf)
+			if (n <= 0) || (uint64(len(buf)-n) < size) {
+				t.Fatalf("invalid length of field %d", field)
+			}
+			ret[field], buf = append(ret[field], buf[n:n+int(size)]), buf[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", wire_type, field)
+		}
+	}
+	return ret
+}
+
+// testProtoPacked decodes the packed repeated varints `buf`.
+func testProtoPacked(t *testing.T, buf []byte) (ret []uint64) {
+	for len(buf) > 0 {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			t.Fatal("invalid packed varint")
+		}
+		ret, buf = append(ret, value), buf[n:]
+	}
+	return
+}
+
+// TestProfilerWritePprof decodes the `WritePprof` output by the fields of the `Profile` message of
+// github.com/google/pprof/proto/profile.proto that it writes.
+func TestProfilerWritePprof(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile(t).Profiler.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := testProtoFields(t, raw)
+
+	var strs []string
+	for _, it := range profile[6] {
+		strs = append(strs, string(it.([]byte)))
+	}
+	if (len(strs) == 0) || (strs[0] != "") {
+		t.Fatalf("expected the string table to begin with \"\", got: %q", strs)
+	}
+	str := func(idx any) string {
+		if i, ok := idx.(uint64); ok && (i < uint64(len(strs))) {
+			return strs[i]
+		}
+		t.Fatalf("invalid string index: %v", idx)
+		return ""
+	}
+	var sample_types []string
+	for _, it := range profile[1] {
+		value_type := testProtoFields(t, it.([]byte))
+		sample_types = append(sample_types, str(value_type[1][0])+"/"+str(value_type[2][0]))
+	}
+	if have := strings.Join(sample_types, " "); have != "calls/count time/nanoseconds" {
+		t.Errorf("unexpected sample types: %s", have)
+	}
+
+	fn_names := map[uint64]string{} // by `Function` id, which is also the `Location` id
+	for _, it := range profile[5] {
+		fn := testProtoFields(t, it.([]byte))
+		fn_names[fn[1][0].(uint64)] = str(fn[3][0])
+		if file := str(fn[4][0]); file != "prof.lisp" {
+			t.Errorf("unexpected file of %s: %s", str(fn[3][0]), file)
+		}
+	}
+	if len(fn_names) != 3 || len(profile[4]) != 3 {
+		t.Fatalf("expected 3 functions and locations, got: %v", fn_names)
+	}
+	for _, it := range profile[4] {
+		loc := testProtoFields(t, it.([]byte))
+		if line := testProtoFields(t, loc[4][0].([]byte)); line[1][0] != loc[1][0] {
+			t.Errorf("expected location %d to be of function %d, not %d", loc[1][0], loc[1][0], line[1][0])
+		}
+	}
+
+	var stacks []string // of the samples, innermost first, with their calls counts
+	var num_calls uint64
+	for _, it := range profile[2] {
+		sample := testProtoFields(t, it.([]byte))
+		var names []string
+		for _, loc := range testProtoPacked(t, sample[1][0].([]byte)) {
+			names = append(names, fn_names[loc])
+		}
+		values := testProtoPacked(t, sample[2][0].([]byte))
+		if len(values) != 2 {
+			t.Fatalf("expected 2 values per sample, got %v", values)
+		}
+		num_calls += values[0]
+		stacks = append(stacks, strings.Join(names, "<")+" x"+strconv.FormatUint(values[0], 10))
+	}
+	slices.Sort(stacks)
+	if have := strings.Join(stacks, " "); have != "leaf x1 leaf<mid<mid<top x1 mid<mid<mid<top x1 mid<mid<top x1 mid<top x1 top x1" {
+		t.Errorf("unexpected samples: %s", have)
+	} else if num_calls != 6 {
+		t.Errorf("expected 6 calls in all, got %d", num_calls)
+	}
+	if duration := profile[10]; (len(duration) != 1) || (duration[0].(uint64) < uint64(120*time.Millisecond)) {
+		t.Errorf("unexpected duration: %v", duration)
+	}
+}
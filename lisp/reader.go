@@ -0,0 +1,343 @@
+package lisp
+
+// all adopted from github.com/kanaka/mal/blob/master/impls/go/src/reader/reader.go and restyled slightly
+
+// the parser (`readForm` and its callees) reads one complete form and leaves any further tokens to the
+// caller: `FormReader` reads them as the next forms, `readExpr` rejects them as trailing garbage.
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// Work around lack of quoting in backtick
+var tokenRegex = regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'´` + "`" + `~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" + `,;)]*)`)
+
+type Reader interface {
+	next() *Token
+	peek() *Token
+	eof() *SrcSpan
+}
+
+type Token struct {
+	Src    string
+	Span   SrcSpan
+	idxEnd int // byte offset right after this token in the tokenized source
+}
+
+type TokenReader struct {
+	tokens   []Token
+	position int
+	end      SrcSpan
+}
+
+func (me *TokenReader) next() *Token {
+	if me.position >= len(me.tokens) {
+		return nil
+	}
+	token := &me.tokens[me.position]
+	me.position = me.position + 1
+	return token
+}
+
+func (me *TokenReader) peek() *Token {
+	if me.position >= len(me.tokens) {
+		return nil
+	}
+	return &me.tokens[me.position]
+}
+
+func (me *TokenReader) eof() *SrcSpan {
+	return &me.end
+}
+
+// tokenize splits `src` into tokens, with `from` being the source location of `src[0]`.
+func tokenize(from SrcSpan, src string) (tokens []Token, end SrcSpan) {
+	tokens, end = make([]Token, 0, 1), SrcSpan{File: from.File, Line: from.Line, Col: from.Col}
+	advance := func(s string) { // moves `end` past `s`
+		for _, r := range s {
+			if r == '\n' {
+				end.Line, end.Col = end.Line+1, 1
+			} else {
+				end.Col++
+			}
+		}
+	}
+	var idx_prev int
+	for _, group := range tokenRegex.FindAllStringSubmatchIndex(src, -1) {
+		idx_start, idx_end := group[2], group[3]
+		advance(src[idx_prev:idx_start])
+		token := Token{Src: src[idx_start:idx_end], Span: end, idxEnd: idx_end}
+		advance(token.Src)
+		idx_prev = idx_end
+		if (token.Src == "") || (token.Src[0] == ';') {
+			continue
+		}
+		token.Span.LineEnd, token.Span.ColEnd = end.Line, end.Col
+		tokens = append(tokens, token)
+	}
+	advance(src[idx_prev:])
+	end.LineEnd, end.ColEnd = end.Line, end.Col
+	return
+}
+
+func errReader(span *SrcSpan, msg string) error {
+	return &SrcErr{Span: span, Err: errors.New(msg)}
+}
+
+// errInputEnded marks reader errors caused by the input ending before the form did, ie. more input might complete the form.
+type errInputEnded struct{ msg string }
+
+func (me *errInputEnded) Error() string { return me.msg }
+
+func errReaderEOF(span *SrcSpan, msg string) error {
+	return &SrcErr{Span: span, Err: &errInputEnded{msg: msg}}
+}
+
+func isErrInputEnded(err error) bool {
+	var err_input_ended *errInputEnded
+	return errors.As(err, &err_input_ended)
+}
+
+// spanFrom returns a new span from the start of `start` to the end of `end`.
+func spanFrom(start *SrcSpan, end *SrcSpan, items []*SrcSpan) *SrcSpan {
+	return &SrcSpan{File: start.File, Line: start.Line, Col: start.Col, LineEnd: end.LineEnd, ColEnd: end.ColEnd, Items: items}
+}
+
+func readAtomicExpr(r Reader) (Expr, *SrcSpan, error) {
+	token := r.next()
+	if token == nil {
+		return nil, nil, errReaderEOF(r.eof(), "readAtomicExpr underflow")
+	}
+	tok, span := token.Src, &token.Span
+	if num, err := readNum(tok); (num != nil) || (err != nil) {
+		return num, span, errAtSpan(span, err)
+	} else if match, _ := regexp.MatchString(`^"(?:\\.|[^\\"])*"$`, tok); match {
+		str, err := strconv.Unquote(tok)
+		return ExprStr(str), span, errAtSpan(span, err)
+	} else if (tok)[0] == '"' {
+		return nil, nil, errReaderEOF(span, "expected '\"', got EOF")
+	} else if (tok)[0] == ':' {
+		return ExprKeyword(tok), span, nil
+	} else {
+		return ExprIdent(tok), span, nil
+	}
+}
+
+func readList(r Reader, start string, end string) (ExprList, *SrcSpan, error) {
+	token := r.next()
+	if token == nil {
+		return nil, nil, errReaderEOF(r.eof(), "readList underflow")
+	} else if token.Src != start {
+		return nil, nil, errReader(&token.Span, "expected '"+start+"'")
+	}
+	span_start := &token.Span
+
+	var ast_list ExprList
+	var spans []*SrcSpan
+	for token = r.peek(); true; token = r.peek() {
+		if token == nil {
+			return nil, nil, errReaderEOF(span_start, "expected '"+end+"', got EOF")
+		}
+		if token.Src == end {
+			break
+		}
+		form, span, err := readForm(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		ast_list, spans = append(ast_list, form), append(spans, span)
+	}
+	return ast_list, spanFrom(span_start, &r.next().Span, spans), nil
+}
+
+func readVec(r Reader) (Expr, *SrcSpan, error) {
+	list, span, err := readList(r, "[", "]")
+	if err != nil {
+		return nil, nil, err
+	}
+	vec := ExprVec(list)
+	srcSpanSet(vec, span)
+	return vec, span, nil
+}
+
+func readHashMap(r Reader) (Expr, *SrcSpan, error) {
+	list, span, err := readList(r, "{", "}")
+	if err != nil {
+		return nil, nil, err
+	}
+	hashmap, err := stdHashmap(list)
+	if err != nil {
+		return nil, nil, errAtSpan(span, err)
+	}
+	span.Items = nil
+	srcSpanSet(hashmap, span)
+	return hashmap, span, nil
+}
+
+func readForm(r Reader) (Expr, *SrcSpan, error) {
+	token := r.peek()
+	if token == nil {
+		return nil, nil, errReaderEOF(r.eof(), "readForm underflow")
+	}
+	switch token.Src {
+
+	// short-hands
+	case "'":
+		return readShortHand(r, exprIdentQuote)
+	case "`", "´":
+		return readShortHand(r, exprIdentQuasiQuote)
+	case "~":
+		return readShortHand(r, exprIdentUnquote)
+	case "~@":
+		return readShortHand(r, exprIdentSpliceUnquote)
+	case "@":
+		return readShortHand(r, ExprIdent("await"))
+
+	// list
+	case ")":
+		return nil, nil, errReader(&token.Span, "unexpected ')'")
+	case "(":
+		list, span, err := readList(r, "(", ")")
+		if err != nil {
+			return nil, nil, err
+		}
+		srcSpanSet(list, span)
+		return list, span, nil
+
+	// vector
+	case "]":
+		return nil, nil, errReader(&token.Span, "unexpected ']'")
+	case "[":
+		return readVec(r)
+
+	// hash-map
+	case "}":
+		return nil, nil, errReader(&token.Span, "unexpected '}'")
+	case "{":
+		return readHashMap(r)
+	default:
+		return readAtomicExpr(r)
+	}
+}
+
+// readShortHand reads eg. `'foo` into `(quote foo)`, with `ident` being `quote` in this example.
+func readShortHand(r Reader, ident ExprIdent) (Expr, *SrcSpan, error) {
+	token := r.next()
+	form, span, err := readForm(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	list := ExprList{ident, form}
+	span = spanFrom(&token.Span, span, []*SrcSpan{&token.Span, span})
+	srcSpanSet(list, span)
+	return list, span, nil
+}
+
+func readExpr(srcFilePath string, src string) (Expr, error) {
+	tokens, end := tokenize(SrcSpan{File: srcFilePath, Line: 1, Col: 1}, src)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	r := &TokenReader{tokens: tokens, position: 0, end: end}
+	expr, _, err := readForm(r)
+	if err == nil {
+		if token := r.peek(); token != nil {
+			return nil, errReader(&token.Span, "unexpected '"+token.Src+"' after the end of the form")
+		}
+	}
+	return expr, err
+}
+
+// FormReader reads one top-level form at a time from an `io.Reader`, pulling in more lines only when the forms read so far are incomplete.
+// each line is tokenized once (but for its last token, which might be a string literal continued on the next line), and parsing is
+// only tried once all brackets are closed: so that multi-line forms do not take time quadratic in their number of lines.
+type FormReader struct {
+	src      *bufio.Reader
+	srcEOF   bool
+	pending  string  // read-in but not-yet-tokenized source text
+	pos      SrcSpan // the source location of `pending[0]`
+	tokens   []Token // read-in but not-yet-consumed tokens
+	numOpen  int     // how many more opening than closing brackets there are in `tokens`
+	lastTail string  // the source text from the start of the last of `tokens` on, if it is the last one tokenized
+
+	onMoreInput func(isMidForm bool) // if set, called before reading in another line, eg. by the REPL to print a prompt
+}
+
+func newFormReader(srcFilePath string, src io.Reader) *FormReader {
+	return &FormReader{src: bufio.NewReader(src), pos: SrcSpan{File: srcFilePath, Line: 1, Col: 1}}
+}
+
+// ReadForm returns the next top-level form, or `io.EOF` once no more forms are left.
+func (me *FormReader) ReadForm() (Expr, error) {
+	for {
+		tokens, end := tokenize(me.pos, me.pending)
+		if len(tokens) > 0 {
+			last := &tokens[len(tokens)-1]
+			me.lastTail = me.pending[last.idxEnd-len(last.Src):]
+		}
+		for _, token := range tokens {
+			me.tokens, me.numOpen = append(me.tokens, token), me.numOpen+tokenOpens(token.Src)
+		}
+		me.pending, me.pos = "", end
+		if len(me.tokens) == 0 { // only white-space and comments so far, no need to keep those around
+			if me.srcEOF {
+				return nil, io.EOF
+			}
+		} else if (me.numOpen <= 0) || me.srcEOF { // else the form cannot be complete yet
+			r := &TokenReader{tokens: me.tokens, end: end}
+			expr, _, err := readForm(r)
+			if err == nil {
+				for _, token := range me.tokens[:r.position] {
+					me.numOpen -= tokenOpens(token.Src)
+				}
+				if me.tokens = me.tokens[r.position:]; len(me.tokens) == 0 {
+					me.lastTail = ""
+				}
+				return expr, nil
+			} else if me.srcEOF || !isErrInputEnded(err) {
+				me.DiscardPending() // dont re-report the same error on the next call
+				return nil, err
+			}
+		}
+
+		is_mid_form := len(me.tokens) > 0
+		if is_mid_form && (me.lastTail != "") { // to be re-tokenized along with the next line
+			last := me.tokens[len(me.tokens)-1]
+			me.tokens, me.numOpen = me.tokens[:len(me.tokens)-1], me.numOpen-tokenOpens(last.Src)
+			me.pending, me.pos, me.lastTail = me.lastTail, SrcSpan{File: last.Span.File, Line: last.Span.Line, Col: last.Span.Col}, ""
+		}
+		if me.onMoreInput != nil {
+			me.onMoreInput(is_mid_form)
+		}
+		line, err := me.src.ReadString('\n')
+		if me.pending += line; err == errInputCanceled { // from a `LineEditor`: drop the unfinished form but stay open for more
+			me.DiscardPending()
+			return nil, err
+		} else if err != nil {
+			if me.srcEOF = true; err != io.EOF {
+				return nil, err
+			}
+		}
+	}
+}
+
+// DiscardPending drops any already-read-in but not-yet-consumed source text, such as the rest of the current line.
+func (me *FormReader) DiscardPending() {
+	_, me.pos = tokenize(me.pos, me.pending)
+	me.pending, me.tokens, me.numOpen, me.lastTail = "", nil, 0, ""
+}
+
+// tokenOpens returns 1 for an opening bracket token, -1 for a closing one, else 0.
+func tokenOpens(src string) int {
+	switch src {
+	case "(", "[", "{":
+		return 1
+	case ")", "]", "}":
+		return -1
+	}
+	return 0
+}
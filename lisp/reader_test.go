@@ -0,0 +1,73 @@
+package lisp
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFormReader(t *testing.T) {
+	for src, want := range map[string][]string{
+		"(+ 1 2) (+ 3\n4)\n\n; comment\n(list\n\t\"one\\nstring ;\" ; (\n  [5 6])": {
+			"1:1: (+ 1 2)", "1:9: (+ 3 4)", "5:1: (list \"one\\nstring ;\" [5 6])"},
+		"\"a\\\"b\" :c\n:d":    {"\"a\\\"b\"", ":c", ":d"},
+		"(str \"a\nb\")\n:c":   {"error: 1:6: invalid syntax", ":c"},
+		"(1 2))\n(3)":          {"1:1: (1 2)", "error: 1:6: unexpected ')'", "2:1: (3)"},
+		"(1\n(2 \"3\n":         {"error: 2:4: expected '\"', got EOF"},
+		"(1\n(2 3\n":           {"error: 2:1: expected ')', got EOF"},
+		"'\nfoo ; bar\n\n{:a}": {"1:1: (quote foo)", "error: 4:1: expected an even number of arguments, not 1"},
+	} {
+		var have []string
+		forms := newFormReader("", strings.NewReader(src))
+		for {
+			expr, err := forms.ReadForm()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				have = append(have, "error: "+err.Error())
+			} else if span := srcSpanOf(expr); span != nil {
+				have = append(have, span.String()+": "+str(true, expr))
+			} else {
+				have = append(have, str(true, expr))
+			}
+		}
+		if strings.Join(have, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%q\n    expected:\n%s\n    got:\n%s", src, strings.Join(want, "\n"), strings.Join(have, "\n"))
+		}
+	}
+}
+
+// TestSrcSpanOfSlices checks that only the very lists and vectors read have spans, not any sub-slices that share their backing storage.
+func TestSrcSpanOfSlices(t *testing.T) {
+	expr, err := readExpr("", "(a (b c d) [e f])")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := expr.(ExprList)
+	inner, vec := list[1].(ExprList), list[2].(ExprVec)
+	for expected, it := range map[string]Expr{
+		"1:1":  list,
+		"1:4":  inner,
+		"1:12": vec,
+	} {
+		if span := srcSpanOf(it); (span == nil) || (span.String() != expected) {
+			t.Errorf("%s: expected a span at %s, got %v", str(true, it), expected, span)
+		}
+	}
+	for _, it := range []Expr{list[:2], list[1:], inner[:1], inner[:2], vec[:1], ExprList(vec[:1])} {
+		if span := srcSpanOf(it); span != nil {
+			t.Errorf("%s: expected no span, got %s", str(true, it), span)
+		}
+	}
+
+	interp := NewInterpreter(Options{})
+	if _, err = interp.ReadAndEval("", "(def firstTwo (macro (form) (at form 0 2)))"); err != nil {
+		t.Fatal(err)
+	}
+	expanded, err := interp.ReadAndEval("", "(macroExpand (firstTwo (a b c)))")
+	if err != nil {
+		t.Fatal(err)
+	} else if span := srcSpanOf(expanded); span != nil {
+		t.Errorf("%s: expected no span, got %s", str(true, expanded), span)
+	}
+}
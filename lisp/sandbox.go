@@ -0,0 +1,106 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Sandbox limits what scripts can do, for running untrusted ones via `Options.Sandbox`. zero limits mean no limit.
+// in a sandboxed `Interpreter`, the builtins in `sandboxOmitted` (and the `debug` and `future` special forms) do not exist, and going over a limit fails the
+// evaluation with a `*SandboxErr`, which scripts can `catch` (though past the `MaxSteps` or `MaxNodes` of an evaluation, every further step in it fails again).
+// all limits are per top-level evaluation, ie. per `Eval` or `EvalContext` call and per form in `Load`: calls of `fn`s from Go outside of
+// any (such as by bound Go funcs) count their `MaxSteps` and `MaxNodes` together, over the whole life of the `Interpreter`.
+type Sandbox struct {
+	// iterations of the TCO loop in `evalAndApply` (not counting the mini-stdlib)
+	MaxSteps int64
+	// nesting depth of non-tail calls (counting those of `fn`s by Go builtins such as `apply` too)
+	MaxDepth int
+	// `Expr` nodes produced by builtins and by vector and hash-map literals: a list, vector or hash-map counts
+	// as 1 plus its number of items, a string as 1 plus 1 per 16 bytes, all else as 1
+	MaxNodes int64
+	// time until the evaluation gets canceled, also while blocked in `chanSend`, `chanRecv` or `await`
+	MaxWallTime time.Duration
+}
+
+// SandboxErr is the error of going over a `Sandbox` limit.
+type SandboxErr struct {
+	Limit string // "MaxSteps", "MaxDepth", "MaxNodes" or "MaxWallTime"
+	Max   any    // the value of that limit
+}
+
+func (me *SandboxErr) Error() string {
+	return fmt.Sprintf("sandbox limit exceeded: %s of %v", me.Limit, me.Max)
+}
+
+// sandboxOmitted are the builtins not available in a sandboxed `Interpreter` (and so neither are their `Options.MalCompat` aliases).
+// `go` and `pmap` (like the `future` special form) are among them as their goroutines would outlive the evaluation starting them.
+var sandboxOmitted = []ExprIdent{"readTextFile", "loadFile", "readLine", "quit", "exit", "eval", "go", "pmap"}
+
+// sandboxEval holds the usage so far of the `Sandbox.MaxSteps` and `Sandbox.MaxNodes` of one top-level evaluation,
+// as carried by its `context.Context` (see `sandboxContext`).
+type sandboxEval struct {
+	steps atomic.Int64
+	nodes atomic.Int64
+}
+
+type sandboxEvalKey struct{}
+
+// sandboxEvalOf returns the `sandboxEval` of the top-level evaluation that `ctx` is of, or else that of all calls outside of any.
+func (me *Interpreter) sandboxEvalOf(ctx context.Context) *sandboxEval {
+	if eval, _ := ctx.Value(sandboxEvalKey{}).(*sandboxEval); eval != nil {
+		return eval
+	}
+	return me.sandbox
+}
+
+func (me *Interpreter) sandboxStep(ctx context.Context) error {
+	if max := me.Options.Sandbox.MaxSteps; (max > 0) && (me.sandboxEvalOf(ctx).steps.Add(1) > max) {
+		return &SandboxErr{Limit: "MaxSteps", Max: max}
+	}
+	return nil
+}
+
+func (me *Interpreter) sandboxAlloc(ctx context.Context, expr Expr) error {
+	if max := me.Options.Sandbox.MaxNodes; (max > 0) && (me.sandboxEvalOf(ctx).nodes.Add(exprNodesCount(expr)) > max) {
+		return &SandboxErr{Limit: "MaxNodes", Max: max}
+	}
+	return nil
+}
+
+// sandboxDepth returns the call depth for `evalAndApply` to run at in `env`, or an error if that goes over `Sandbox.MaxDepth`.
+func (me *Interpreter) sandboxDepth(env *Env) (int, error) {
+	max := me.Options.Sandbox.MaxDepth
+	if max <= 0 {
+		return 0, nil
+	}
+	depth := env.callDepth() + 1
+	if depth > max {
+		return 0, &SandboxErr{Limit: "MaxDepth", Max: max}
+	}
+	return depth, nil
+}
+
+// sandboxContext returns `ctx` for a new top-level evaluation: with the `Sandbox.MaxWallTime` deadline, if any, and a new `sandboxEval`.
+func (me *Interpreter) sandboxContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if max := me.Options.Sandbox.MaxWallTime; max > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, max, &SandboxErr{Limit: "MaxWallTime", Max: max})
+	}
+	return context.WithValue(ctx, sandboxEvalKey{}, &sandboxEval{}), cancel
+}
+
+func exprNodesCount(expr Expr) int64 {
+	switch it := expr.(type) {
+	case ExprList:
+		return 1 + int64(len(it))
+	case ExprVec:
+		return 1 + int64(len(it))
+	case ExprHashMap:
+		return 1 + int64(len(it))
+	case ExprStr:
+		return 1 + int64(len(it)/16)
+	}
+	return 1
+}
@@ -0,0 +1,85 @@
+package lisp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestSandboxMaxDepth checks that `Sandbox.MaxDepth` holds for recursion through Go builtins (which call `fn`s via
+// `ExprFn.Call`) as for direct recursion, both interpreted and in the VM.
+func TestSandboxMaxDepth(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		interp := NewInterpreter(Options{Bytecode: bytecode, Sandbox: &Sandbox{MaxDepth: 50, MaxWallTime: 10 * time.Second}})
+		for _, src := range []string{
+			"(def down (fn (n) (if (= n 0) 0 (+ 1 (down (- n 1))))))",
+			"(def downApply (fn (n) (if (= n 0) 0 (+ 1 (apply downApply (list (- n 1)))))))",
+			"(def forever (fn (n) (+ 1 (apply forever (list n)))))",
+		} {
+			if _, err := interp.ReadAndEval("", src); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for src, exceeds := range map[string]bool{
+			"(down 20)":      false,
+			"(down 60)":      true,
+			"(downApply 20)": false,
+			"(downApply 60)": true,
+			"(forever 1)":    true,
+		} {
+			_, err := interp.ReadAndEval("", src)
+			var sandbox_err *SandboxErr
+			if exceeds && !(errors.As(err, &sandbox_err) && (sandbox_err.Limit == "MaxDepth")) {
+				t.Errorf("bytecode=%v: %s: expected a MaxDepth error, got: %v", bytecode, src, err)
+			} else if (!exceeds) && (err != nil) {
+				t.Errorf("bytecode=%v: %s: %v", bytecode, src, err)
+			}
+		}
+	}
+}
+
+// TestSandboxMaxWallTime checks that `Sandbox.MaxWallTime` holds also while blocked in builtins, and in calls of `fn`s by builtins.
+func TestSandboxMaxWallTime(t *testing.T) {
+	interp := NewInterpreter(Options{Sandbox: &Sandbox{MaxWallTime: 100 * time.Millisecond}})
+	for _, src := range []string{
+		"(def full (chanNew 1))",
+		"(chanSend full 1)",
+		"(def spin (fn () (spin)))",
+	} {
+		if _, err := interp.ReadAndEval("", src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, src := range []string{"(chanRecv (chanNew))", "(chanSend full 2)", "(await (promise))", "(apply spin (list))", "(atomSwap (atomFrom 0) (fn (n) (spin)))"} {
+		start := time.Now()
+		_, err := interp.ReadAndEval("", src)
+		var sandbox_err *SandboxErr
+		if !(errors.As(err, &sandbox_err) && (sandbox_err.Limit == "MaxWallTime")) {
+			t.Errorf("%s: expected a MaxWallTime error, got: %v", src, err)
+		} else if took := time.Since(start); took > 2*time.Second {
+			t.Errorf("%s: took %s", src, took)
+		}
+	}
+}
+
+// TestSandboxPerEval checks that `Sandbox.MaxSteps` and `Sandbox.MaxNodes` hold per top-level evaluation, not for all of them together.
+func TestSandboxPerEval(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		for limit, sandbox := range map[string]*Sandbox{"MaxSteps": {MaxSteps: 1000}, "MaxNodes": {MaxNodes: 1000}} {
+			interp := NewInterpreter(Options{Bytecode: bytecode, Sandbox: sandbox})
+			if _, err := interp.ReadAndEval("", "(def countDown (fn (n) (if (= n 0) (list) (cons n (countDown (- n 1))))))"); err != nil {
+				t.Fatal(err)
+			}
+			for range 100 {
+				if _, err := interp.ReadAndEval("", "(countDown 10)"); err != nil {
+					t.Fatalf("bytecode=%v: %s: %v", bytecode, limit, err)
+				}
+			}
+			_, err := interp.ReadAndEval("", "(countDown 1000)")
+			var sandbox_err *SandboxErr
+			if !(errors.As(err, &sandbox_err) && (sandbox_err.Limit == limit)) {
+				t.Errorf("bytecode=%v: expected a %s error, got: %v", bytecode, limit, err)
+			}
+		}
+	}
+}
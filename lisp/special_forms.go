@@ -1,6 +1,7 @@
-package main
+package lisp
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -12,10 +13,7 @@ var (
 	exprIdentSpliceUnquote = ExprIdent("spliceUnquote")
 	exprIdentMacro         = ExprIdent("macro")
 
-	specialForms map[ExprIdent]SpecialForm
-)
-
-func init() { // in here, instead of above, to avoid "initialization cycle" error:
+	// the special forms every new `Interpreter` starts out with
 	specialForms = map[ExprIdent]SpecialForm{
 		"def":               stdDef,
 		"set":               stdSet,
@@ -27,8 +25,14 @@ func init() { // in here, instead of above, to avoid "initialization cycle" erro
 		exprIdentQuasiQuote: stdQuasiQuote,
 		"macroExpand":       stdMacroExpand,
 		"try":               stdTryCatch,
+		"select":            stdSelect,
+		"future":            stdFuture,
+		"withTimeout":       stdWithTimeout,
+		"debug":             stdDebug,
+		"deftest":           stdDefTest,
+		"assertThrows":      stdAssertThrows,
 	}
-}
+)
 
 // like the funcs in `std.go`, the special-forms return an `Expr, error`. but:
 // in addition, they also return an `*Env`. if that is `nil`, the returned Expr
@@ -42,45 +46,69 @@ func stdSet(env *Env, args []Expr) (*Env, Expr, error) {
 	return defOrSet(false, env, args)
 }
 func defOrSet(isDef bool, env *Env, args []Expr) (*Env, Expr, error) {
-	if err := checkArgsCount(2, 2, "`def` and `set`", args); err != nil {
-		return nil, nil, err
-	}
-	name, err := checkIs[ExprIdent](args[0])
+	return defOrSetWith(isDef, env, args, nil)
+}
+
+// defOrSetWith is `defOrSet` with `value`, unless `nil`, being `args[1]` already compiled.
+func defOrSetWith(isDef bool, env *Env, args []Expr, value compiled) (*Env, Expr, error) {
+	name, err := defOrSetName(isDef, env, args)
 	if err != nil {
 		return nil, nil, err
 	}
-	if _, is_reserved := specialForms[name]; is_reserved {
-		return nil, nil, fmt.Errorf("cannot redefine `%s`", name)
-	}
-	if !isDef { // check if this `set` refers to something that's been `def`d
-		if _, err := env.get(name); err != nil {
-			return nil, nil, err
-		}
-	} else if env.hasOwn(name) && !malCompat { // cannot re`def` locals
-		return nil, nil, fmt.Errorf("cannot redefine `%s` (use `set` here instead of `def`)", name)
-	}
 
 	var ret Expr
 	if maybe_macro, is_macro, err := isListStartingWithIdent(args[1], exprIdentMacro, -1); err != nil {
 		return nil, nil, err
 	} else if is_macro {
-		_, maybe_macro, err := stdFn(env, maybe_macro[1:])
-		if err != nil {
-			return nil, nil, err
-		}
-		macro, err := checkIs[*ExprFn](maybe_macro)
-		if err != nil {
+		if ret, err = newFn(env, maybe_macro[1:]); err != nil {
 			return nil, nil, err
 		}
-		macro.isMacro = true
-		ret = macro
-	} else if ret, err = evalAndApply(env, args[1]); err != nil {
+	} else if ret, err = value.evalOr(env, args[1]); err != nil {
 		return nil, nil, err
 	}
-	env.set(name, ret)
+	defBind(env, name, args[1], ret)
 	return nil, ret, nil
 }
 
+// defOrSetName checks the `(def name expr)` or `(set name expr)` form (before `expr` is evaluated) and returns its `name`.
+func defOrSetName(isDef bool, env *Env, args []Expr) (ExprIdent, error) {
+	if err := checkArgsCount(2, 2, "`def` and `set`", args); err != nil {
+		return "", err
+	}
+	name, err := checkIs[ExprIdent](args[0])
+	if err != nil {
+		return "", err
+	}
+	if _, is_reserved := env.interp.specialForms[name]; is_reserved {
+		return "", fmt.Errorf("cannot redefine `%s`", name)
+	}
+	if !isDef { // check if this `set` refers to something that's been `def`d
+		if _, err := env.get(name); err != nil {
+			return "", err
+		}
+	} else if env.hasOwn(name) && !env.interp.Options.MalCompat { // cannot re`def` locals
+		return "", fmt.Errorf("cannot redefine `%s` (use `set` here instead of `def`)", name)
+	}
+	return name, nil
+}
+
+// defBind binds `name` to `value`, which `form` evaluated to: if that is a `fn` or `macro` form, that new `*ExprFn` gets named (and made a macro).
+func defBind(env *Env, name ExprIdent, form Expr, value Expr) {
+	fn, _ := value.(*ExprFn)
+	if fn != nil {
+		if _, is_macro, _ := isListStartingWithIdent(form, exprIdentMacro, -1); is_macro {
+			fn.isMacro, fn.nameMaybe = true, "`"+string(name)+"`"
+		} else if list, _ := form.(ExprList); (len(list) > 0) && ((list[0] == ExprIdent("fn")) || (list[0] == ExprIdent("fn*"))) { // not yet seen by anyone else, so can still be named
+			fn.nameMaybe = "`" + string(name) + "`"
+		}
+	}
+	old, _ := env.find(name).(*ExprFn)
+	env.set(name, value)
+	if ((fn != nil) && fn.isMacro) || ((old != nil) && old.isMacro) { // then all compiled `fn` bodies might have outdated expansions
+		env.interp.macroDefs.Add(1)
+	}
+}
+
 func stdDo(env *Env, args []Expr) (tailEnv *Env, expr Expr, err error) {
 	if err = checkArgsCount(1, -1, "`do`", args); err != nil {
 		return
@@ -95,6 +123,23 @@ func stdDo(env *Env, args []Expr) (tailEnv *Env, expr Expr, err error) {
 }
 
 func stdLet(env *Env, args []Expr) (*Env, Expr, error) {
+	names, exprs, err := letBindings(env.interp, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	let_env := newEnv(env, nil, nil)
+	for i, name := range names {
+		expr, err := evalAndApply(let_env, exprs[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		let_env.set(name, expr)
+	}
+	return stdDo(let_env, args[1:])
+}
+
+// letBindings checks the `(let ((name expr)...) body...)` form and returns its names and their exprs.
+func letBindings(interp *Interpreter, args []Expr) (names []ExprIdent, exprs []Expr, err error) {
 	if err := checkArgsCount(2, -1, "`let`", args); err != nil {
 		return nil, nil, err
 	}
@@ -102,7 +147,6 @@ func stdLet(env *Env, args []Expr) (*Env, Expr, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	let_env := newEnv(env, nil, nil)
 	for _, binding := range bindings {
 		pair, err := checkIsSeq(binding)
 		if err != nil {
@@ -115,47 +159,73 @@ func stdLet(env *Env, args []Expr) (*Env, Expr, error) {
 		if err != nil {
 			return nil, nil, err
 		}
-		if _, is_special := specialForms[name]; is_special {
+		if _, is_special := interp.specialForms[name]; is_special {
 			return nil, nil, fmt.Errorf("cannot redefine `%s`", name)
 		}
-		expr, err := evalAndApply(let_env, pair[1])
-		if err != nil {
-			return nil, nil, err
-		}
-		let_env.set(name, expr)
+		names, exprs = append(names, name), append(exprs, pair[1])
 	}
-	return stdDo(let_env, args[1:])
+	return
 }
 
 func stdIf(env *Env, args []Expr) (*Env, Expr, error) {
 	if err := checkArgsCount(2, 3, "`if`", args); err != nil {
 		return nil, nil, err
 	}
-	if len(args) < 3 {
-		args = append(args, exprNil)
-	}
 	expr, err := evalAndApply(env, args[0])
 	if err != nil {
 		return nil, nil, err
 	}
-	idx := 1
-	if isNilOrFalse(expr) {
-		idx = 2
+	is_then := !isNilOrFalse(expr)
+	if env.interp.Coverage.on.Load() {
+		env.interp.Coverage.branchTaken(args, is_then)
+	}
+	if is_then {
+		return env, args[1], nil
+	} else if len(args) < 3 {
+		return env, exprNil, nil
 	}
-	return env, args[idx], nil
+	return env, args[2], nil
 }
 
 func stdFn(env *Env, args []Expr) (*Env, Expr, error) {
-	if err := checkArgsCount(2, -1, "`fn`", args); err != nil {
+	fn, err := newFn(env, args)
+	if err != nil {
 		return nil, nil, err
 	}
+	if env.interp.Options.NoTco {
+		return nil, (ExprFunc)(fn.Call), nil
+	}
+	return nil, fn, nil
+}
+
+func newFn(env *Env, args []Expr) (*ExprFn, error) {
+	fn, err := fnFrom(args)
+	if err != nil {
+		return nil, err
+	}
+	fn.env = fnEnv(env)
+	return fn, nil
+}
+
+// fnEnv returns the env for a new `fn` made in `env` to capture.
+func fnEnv(env *Env) *Env {
+	for env.isTransparent() { // dont capture any `ctx` that is only for the evaluation creating the `fn`: calls get their caller's instead
+		env = env.Parent
+	}
+	return env
+}
 
+// fnFrom checks the `(fn (params...) body...)` form and returns an `*ExprFn` for it, but without an `env` yet.
+func fnFrom(args []Expr) (*ExprFn, error) {
+	if err := checkArgsCount(2, -1, "`fn`", args); err != nil {
+		return nil, err
+	}
 	params, err := checkIsSeq(args[0])
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	if err := checkAre[ExprIdent](params...); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	var is_variadic bool
 	if len(params) >= 2 {
@@ -169,12 +239,7 @@ func stdFn(env *Env, args []Expr) (*Env, Expr, error) {
 	if len(args) > 2 {
 		body = append(ExprList{exprIdentDo}, args[1:]...)
 	}
-	var expr Expr = &ExprFn{params: params, body: body, env: env, isVariadic: is_variadic}
-
-	if disableTcoFuncs {
-		expr = (ExprFunc)(expr.(*ExprFn).Call)
-	}
-	return nil, expr, nil
+	return &ExprFn{params: params, body: body, isVariadic: is_variadic, code: &fnCode{}}, nil
 }
 
 func stdMacroExpand(env *Env, args []Expr) (*Env, Expr, error) {
@@ -226,7 +291,7 @@ func stdQuasiQuote(env *Env, args []Expr) (*Env, Expr, error) {
 			} else {
 				expr = append(expr, unquoted)
 			}
-		} else if splice_unquote, ok, err := isListStartingWithIdent(item, exprIdentSpliceUnquote, 2); err != nil {
+		} else if splice_unquote, ok, err := isSpliceUnquote(env.interp, item); err != nil {
 			return nil, nil, err
 		} else if ok {
 			evaled, err := evalAndApply(env, splice_unquote[1])
@@ -258,38 +323,78 @@ func stdQuasiQuote(env *Env, args []Expr) (*Env, Expr, error) {
 	return nil, expr, nil
 }
 
+// isSpliceUnquote checks whether `expr` is a `(spliceUnquote it)` (or, with `Options.MalCompat`, a `(splice-unquote it)`).
+func isSpliceUnquote(interp *Interpreter, expr Expr) (ExprList, bool, error) {
+	list, ok, err := isListStartingWithIdent(expr, exprIdentSpliceUnquote, 2)
+	if (!ok) && (err == nil) && interp.Options.MalCompat {
+		list, ok, err = isListStartingWithIdent(expr, "splice-unquote", 2)
+	}
+	return list, ok, err
+}
+
 func stdTryCatch(env *Env, args []Expr) (*Env, Expr, error) {
-	if err := checkArgsCount(1, 2, "`try`", args); err != nil {
+	catch, err := tryCatchClause(env.interp, args)
+	if err != nil {
 		return nil, nil, err
-	}
-	if len(args) == 1 {
+	} else if catch == nil {
 		return env, args[0], nil
 	}
-
-	catch, ok, err := isListStartingWithIdent(args[1], "catch", 3)
-	if err != nil {
+	expr, err := evalAndApply(env, args[0])
+	if isErrExit(err) {
 		return nil, nil, err
-	} else if !ok {
-		if malCompat {
-			catch, ok, _ = isListStartingWithIdent(args[1], "catch*", 3) // MAL compat for testing `./self-hosted-mal/*.mal`s
-		}
-		if !ok {
-			return nil, nil, fmt.Errorf("expected `(catch theErr exprHandlingIt)` as the last form in `try` , instead of `%s`", str(true, args[1]))
-		}
+	} else if err != nil {
+		tryCatchBind(env, catch, err)
+		return env, catch[len(catch)-1], nil
 	}
+	return nil, expr, nil
+}
 
-	expr, err := evalAndApply(env, args[0])
-	if err != nil {
-		err_expr, is := err.(ExprErr)
-		if !is {
-			err_expr = ExprErr{It: err}
-		}
-		if expr, is = err_expr.It.(Expr); !is {
-			expr = err_expr
-		}
-		env.set(catch[1].(ExprIdent), expr)
-		return env, catch[2], nil
+// tryCatchClause checks the `(try expr (catch theErr exprHandlingIt))` form and returns its `catch` clause, if any.
+func tryCatchClause(interp *Interpreter, args []Expr) ([]Expr, error) {
+	if err := checkArgsCount(1, 2, "`try`", args); err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return nil, nil
 	}
 
-	return nil, expr, nil
+	catch, ok, _ := isListStartingWithIdent(args[1], "catch", -1)
+	if (!ok) && interp.Options.MalCompat {
+		catch, ok, _ = isListStartingWithIdent(args[1], "catch*", -1) // MAL compat for testing `./self-hosted-mal/*.mal`s
+	}
+	if !ok {
+		return nil, fmt.Errorf("expected `(catch theErr exprHandlingIt)` or `(catch theErr theStackTrace exprHandlingIt)` as the last form in `try` , instead of `%s`", str(true, args[1]))
+	} else if err := checkArgsCount(3, 4, "form `catch`", catch); err != nil {
+		return nil, err
+	} else if err = checkAre[ExprIdent](catch[1 : len(catch)-1]...); err != nil {
+		return nil, err
+	}
+	return catch, nil
+}
+
+// errExpr returns what `err` is caught as by `try`: the value `throw`n if any, else `err` as an `ExprErr`.
+func errExpr(err error) Expr {
+	var err_expr ExprErr
+	is := errors.As(err, &err_expr)
+	if !is {
+		err_expr = ExprErr{It: err}
+	}
+	var expr Expr
+	if expr, is = err_expr.It.(Expr); !is {
+		expr = err_expr
+	}
+	return expr
+}
+
+// tryCatchBind binds the names of the `catch` clause to `err` (and its stack trace) in `env`.
+func tryCatchBind(env *Env, catch []Expr, err error) {
+	env.set(catch[1].(ExprIdent), errExpr(err))
+	if len(catch) == 4 { // the optional stack trace: a list of `{:fn :file :line :col}` hashmaps, innermost call first
+		stack := ExprList{}
+		var stack_err *StackErr
+		if errors.As(err, &stack_err) {
+			stack = stack_err.stackExpr()
+		}
+		env.set(catch[2].(ExprIdent), stack)
+	}
 }
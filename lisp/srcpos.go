@@ -0,0 +1,119 @@
+package lisp
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"unsafe"
+	"weak"
+)
+
+// SrcSpan locates a form in its source text. lines and columns are 1-based, columns count runes, `ColEnd` is exclusive.
+type SrcSpan struct {
+	File    string
+	Line    int
+	Col     int
+	LineEnd int
+	ColEnd  int
+	Items   []*SrcSpan // for lists and vectors only: the spans of their items (hashmap items get reordered anyway)
+}
+
+func (me *SrcSpan) String() string {
+	loc := strconv.Itoa(me.Line) + ":" + strconv.Itoa(me.Col)
+	if me.File != "" {
+		loc = me.File + ":" + loc
+	}
+	return loc
+}
+
+// since `ExprList`, `ExprVec` and `ExprHashMap` are plain slices and maps, their spans cannot live inside them.
+// instead the reader records them in this side-table, keyed by (weak pointers to) their backing storage plus, for
+// lists and vectors, their length: so a sub-slice starting at the same item (such as `(at form 0 2)`) is not taken for the whole form.
+// that means only forms produced by the reader have spans: (non-empty) lists and vectors and all hashmaps.
+// any atomic forms (idents, numbers etc.) are located only via their parent's `SrcSpan.Items`.
+var srcSpans = struct {
+	sync.Mutex
+	m map[srcSpanKey]*SrcSpan
+}{m: map[srcSpanKey]*SrcSpan{}}
+
+type srcSpanKey struct {
+	ptr weak.Pointer[byte]
+	len int // of the list or vector, or 0 for a hashmap
+}
+
+// srcSpanPtr returns the backing storage and length of `expr`, or `nil` if it cannot have a span.
+func srcSpanPtr(expr Expr) (*byte, int) {
+	switch it := expr.(type) {
+	case ExprList:
+		if len(it) > 0 {
+			return (*byte)(unsafe.Pointer(unsafe.SliceData(it))), len(it)
+		}
+	case ExprVec:
+		if len(it) > 0 {
+			return (*byte)(unsafe.Pointer(unsafe.SliceData(it))), len(it)
+		}
+	case ExprHashMap:
+		if it != nil {
+			return (*byte)(reflect.ValueOf(it).UnsafePointer()), 0
+		}
+	}
+	return nil, 0
+}
+
+func srcSpanSet(expr Expr, span *SrcSpan) {
+	ptr, length := srcSpanPtr(expr)
+	if ptr == nil {
+		return
+	}
+	key := srcSpanKey{ptr: weak.Make(ptr), len: length}
+	srcSpans.Lock()
+	srcSpans.m[key] = span
+	srcSpans.Unlock()
+	runtime.AddCleanup(ptr, func(key srcSpanKey) {
+		srcSpans.Lock()
+		delete(srcSpans.m, key)
+		srcSpans.Unlock()
+	}, key)
+}
+
+func srcSpanOf(expr Expr) *SrcSpan {
+	ptr, length := srcSpanPtr(expr)
+	if ptr == nil {
+		return nil
+	}
+	srcSpans.Lock()
+	defer srcSpans.Unlock()
+	return srcSpans.m[srcSpanKey{ptr: weak.Make(ptr), len: length}]
+}
+
+// SrcErr is an error that knows the source location of the form that caused it.
+type SrcErr struct {
+	Span *SrcSpan
+	Err  error
+}
+
+func (me *SrcErr) Error() string { return me.Span.String() + ": " + me.Err.Error() }
+func (me *SrcErr) Unwrap() error { return me.Err }
+
+// errAt attaches the source location of `expr` to `err`, unless `err` already carries a more precise one.
+func errAt(expr Expr, err error) error {
+	return errAtSpan(srcSpanOf(expr), err)
+}
+
+// errAtItem is like `errAt` for the `idx`th item of `parent`, which also locates atomic forms.
+func errAtItem(parent Expr, idx int, err error) error {
+	if span := srcSpanOf(parent); (span != nil) && (idx < len(span.Items)) {
+		return errAtSpan(span.Items[idx], err)
+	}
+	return errAt(parent, err)
+}
+
+func errAtSpan(span *SrcSpan, err error) error {
+	var src_err *SrcErr
+	if (err == nil) || (span == nil) || errors.As(err, &src_err) {
+		return err
+	}
+	return &SrcErr{Span: span, Err: err}
+}
@@ -0,0 +1,75 @@
+package lisp
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// StackFrame is one call in the chain that led to a `StackErr`. note that due to TCO, tail calls replace their caller's frame.
+type StackFrame struct {
+	Name string   // the callee's name if known, else ""
+	Span *SrcSpan // the call-site's source location if known, else `nil`
+}
+
+// StackErr carries the chain of Lisp calls that an error propagated through, innermost call first.
+type StackErr struct {
+	Err   error
+	Stack []StackFrame
+}
+
+func (me *StackErr) Error() string { return me.Err.Error() }
+func (me *StackErr) Unwrap() error { return me.Err }
+
+func (me *StackFrame) String() string {
+	name := "<anonymous>"
+	if me.Name != "" {
+		name = "`" + me.Name + "`"
+	}
+	if me.Span != nil {
+		return name + " (" + me.Span.String() + ")"
+	}
+	return name
+}
+
+func (me *StackErr) stackExpr() ExprList {
+	ret := make(ExprList, 0, len(me.Stack))
+	for _, frame := range me.Stack {
+		hashmap := ExprHashMap{":fn": exprNil, ":file": exprNil, ":line": exprNil, ":col": exprNil}
+		if frame.Name != "" {
+			hashmap[":fn"] = ExprStr(frame.Name)
+		}
+		if frame.Span != nil {
+			hashmap[":file"], hashmap[":line"], hashmap[":col"] = ExprStr(frame.Span.File), ExprNum(frame.Span.Line), ExprNum(frame.Span.Col)
+		}
+		ret = append(ret, hashmap)
+	}
+	return ret
+}
+
+// errWithFrame records `frame` as the next-outer call that `err` propagated through. it does not change `err`, which
+// might propagate through other calls too: such as the error of a `future`, through those of each `await` of it.
+func errWithFrame(err error, frame StackFrame) error {
+	var stack_err *StackErr
+	if errors.As(err, &stack_err) {
+		if err == error(stack_err) { // else keep what wraps it
+			err = stack_err.Err
+		}
+		return &StackErr{Err: err, Stack: append(slices.Clip(stack_err.Stack), frame)}
+	}
+	return &StackErr{Err: err, Stack: []StackFrame{frame}}
+}
+
+// ErrStackTrace renders the error message of `err`, followed by one `at ...` line per frame of its Lisp call chain (if any).
+func ErrStackTrace(err error) string {
+	var buf strings.Builder
+	buf.WriteString(err.Error())
+	var stack_err *StackErr
+	if errors.As(err, &stack_err) {
+		for i := range stack_err.Stack {
+			buf.WriteString("\n    at ")
+			buf.WriteString(stack_err.Stack[i].String())
+		}
+	}
+	return buf.String()
+}
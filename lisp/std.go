@@ -1,6 +1,7 @@
-package main
+package lisp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -10,101 +11,104 @@ import (
 )
 
 var (
-	envMain = Env{Map: map[ExprIdent]Expr{
-		"osArgs":       ExprList{}, // populated by `main` when running a user-specified source file
-		"print":        ExprFunc(stdPrint),
-		"println":      ExprFunc(stdPrintln),
-		"str":          ExprFunc(stdStr),
-		"show":         ExprFunc(stdShow),
-		"is":           ExprFunc(stdIs),
-		"list":         ExprFunc(stdList),
-		"vec":          ExprFunc(stdVec),
-		"vector":       ExprFunc(stdVec),
-		"count":        ExprFunc(stdCount),
-		"isEmpty":      ExprFunc(stdIsEmpty),
-		"cmp":          ExprFunc(stdCmp),
-		"+":            ExprFunc(stdAdd),
-		"-":            ExprFunc(stdSub),
-		"*":            ExprFunc(stdMul),
-		"/":            ExprFunc(stdDiv),
-		"=":            ExprFunc(stdEq),
-		"<":            ExprFunc(stdLt),
-		">":            ExprFunc(stdGt),
-		"<=":           ExprFunc(stdLe),
-		">=":           ExprFunc(stdGe),
-		"readExpr":     ExprFunc(stdReadExpr),
-		"readTextFile": ExprFunc(stdReadTextFile),
-		"atomFrom":     ExprFunc(stdAtomFrom),
-		"atomGet":      ExprFunc(stdAtomGet),
-		"atomSet":      ExprFunc(stdAtomSet),
-		"atomSwap":     ExprFunc(stdAtomSwap),
-		"cons":         ExprFunc(stdCons),
-		"concat":       ExprFunc(stdConcat),
-		"at":           ExprFunc(stdListAt),
-		"error":        ExprFunc(stdError),
-		"throw":        ExprFunc(stdThrow),
-		"ident":        ExprFunc(stdIdent),
-		"keyword":      ExprFunc(stdKeyword),
-		"hashmap":      ExprFunc(stdHashmap),
-		"hashmapSet":   ExprFunc(stdHashmapSet),
-		"hashmapDel":   ExprFunc(stdHashmapDel),
-		"hashmapGet":   ExprFunc(stdHashmapGet),
-		"hashmapHas":   ExprFunc(stdHashmapHas),
-		"hashmapKeys":  ExprFunc(stdHashmapKeys),
-		"hashmapVals":  ExprFunc(stdHashmapVals),
-		"apply":        ExprFunc(stdApply),
-		"readLine":     ExprFunc(stdReadLine),
-		"quit":         ExprFunc(stdQuit),
-		"exit":         ExprFunc(stdQuit),
-		"time-ms":      ExprFunc(stdTimeMs),
-		"bool":         ExprFunc(stdBool),
-		"seq":          ExprFunc(stdSeq),
-		"conj":         ExprFunc(stdConj),
-	}}
+	// the std funcs every new `Interpreter` starts out with, other than those that need one (see `NewInterpreter`)
+	envStd = map[ExprIdent]Expr{
+		"str":               ExprFunc(stdStr),
+		"show":              ExprFunc(stdShow),
+		"is":                ExprFunc(stdIs),
+		"list":              ExprFunc(stdList),
+		"vec":               ExprFunc(stdVec),
+		"vector":            ExprFunc(stdVec),
+		"count":             ExprFunc(stdCount),
+		"isEmpty":           ExprFunc(stdIsEmpty),
+		"cmp":               ExprFunc(stdCmp),
+		"+":                 ExprFunc(stdAdd),
+		"-":                 ExprFunc(stdSub),
+		"*":                 ExprFunc(stdMul),
+		"/":                 ExprFunc(stdDiv),
+		"=":                 ExprFunc(stdEq),
+		"<":                 ExprFunc(stdLt),
+		">":                 ExprFunc(stdGt),
+		"<=":                ExprFunc(stdLe),
+		">=":                ExprFunc(stdGe),
+		"readExpr":          ExprFunc(stdReadExpr),
+		"readTextFile":      ExprFunc(stdReadTextFile),
+		"atomFrom":          ExprFunc(stdAtomFrom),
+		"atomGet":           ExprFunc(stdAtomGet),
+		"atomSet":           ExprFunc(stdAtomSet),
+		"atomSwap":          ExprFunc(stdAtomSwap),
+		"atomCompareAndSet": ExprFunc(stdAtomCompareAndSet),
+		"atomWatch":         ExprFunc(stdAtomWatch),
+		"cons":              ExprFunc(stdCons),
+		"concat":            ExprFunc(stdConcat),
+		"error":             ExprFunc(stdError),
+		"throw":             ExprFunc(stdThrow),
+		"assertEq":          ExprFunc(stdAssertEq),
+		"ident":             ExprFunc(stdIdent),
+		"keyword":           ExprFunc(stdKeyword),
+		"hashmap":           ExprFunc(stdHashmap),
+		"hashmapSet":        ExprFunc(stdHashmapSet),
+		"hashmapDel":        ExprFunc(stdHashmapDel),
+		"hashmapGet":        ExprFunc(stdHashmapGet),
+		"hashmapHas":        ExprFunc(stdHashmapHas),
+		"hashmapKeys":       ExprFunc(stdHashmapKeys),
+		"hashmapVals":       ExprFunc(stdHashmapVals),
+		"apply":             ExprFunc(stdApply),
+		"quit":              ExprFunc(stdQuit),
+		"exit":              ExprFunc(stdQuit),
+		"time-ms":           ExprFunc(stdTimeMs),
+		"bool":              ExprFunc(stdBool),
+		"seq":               ExprFunc(stdSeq),
+		"conj":              ExprFunc(stdConj),
+		"float":             ExprFunc(stdFloat),
+		"int":               ExprFunc(stdInt),
+		"goCall":            ExprFunc(stdGoCall),
+		"goField":           ExprFunc(stdGoField),
+		"chanNew":           ExprFunc(stdChanNew),
+		"chanSend":          ExprFunc(stdChanSend),
+		"chanRecv":          ExprFunc(stdChanRecv),
+		"chanClose":         ExprFunc(stdChanClose),
+		"promise":           ExprFunc(stdPromise),
+		"deliver":           ExprFunc(stdDeliver),
+		"await":             ExprFunc(stdAwait),
+		"pmap":              ExprFunc(stdPmap),
+	}
 )
 
-func init() { // in here, instead of above, to avoid "initialization cycle" error:
-	envMain.Map["eval"] = ExprFunc(stdEval)
-}
-
 func stdAdd(args []Expr) (Expr, error) {
-	op1, op2, err := checkAreBoth[ExprNum, ExprNum](args, true)
-	if err != nil {
-		return nil, err
-	}
-	return op1 + op2, nil
+	return numFold(ExprNum(0), args, numAdd)
 }
 
 func stdSub(args []Expr) (Expr, error) {
-	op1, op2, err := checkAreBoth[ExprNum, ExprNum](args, true)
-	if err != nil {
+	if err := checkArgsCount(1, -1, "`-`", args); err != nil {
 		return nil, err
 	}
-	return op1 - op2, nil
+	if len(args) == 1 { // negation
+		return numSub(ExprNum(0), args[0])
+	}
+	return numFold(args[0], args[1:], numSub)
 }
 
 func stdMul(args []Expr) (Expr, error) {
-	op1, op2, err := checkAreBoth[ExprNum, ExprNum](args, true)
-	if err != nil {
-		return nil, err
-	}
-	return op1 * op2, nil
+	return numFold(ExprNum(1), args, numMul)
 }
 
 func stdDiv(args []Expr) (Expr, error) {
-	op1, op2, err := checkAreBoth[ExprNum, ExprNum](args, true)
-	if err != nil {
+	if err := checkArgsCount(1, -1, "`/`", args); err != nil {
 		return nil, err
 	}
-	return op1 / op2, nil
+	if len(args) == 1 { // reciprocal
+		return numDiv(ExprNum(1), args[0])
+	}
+	return numFold(args[0], args[1:], numDiv)
 }
 
-func stdPrint(args []Expr) (Expr, error) {
-	os.Stdout.WriteString(str(true, args...))
+func (me *Interpreter) stdPrint(args []Expr) (Expr, error) {
+	_, _ = io.WriteString(me.Stdout, str(true, args...))
 	return exprNil, nil
 }
-func stdPrintln(args []Expr) (Expr, error) {
-	os.Stdout.WriteString(str(false, args...) + "\n")
+func (me *Interpreter) stdPrintln(args []Expr) (Expr, error) {
+	_, _ = io.WriteString(me.Stdout, str(false, args...)+"\n")
 	return exprNil, nil
 }
 func stdStr(args []Expr) (Expr, error) {
@@ -135,7 +139,14 @@ func stdIs(args []Expr) (Expr, error) {
 	case ":str":
 		_, ok = args[1].(ExprStr)
 	case ":num":
-		_, ok = args[1].(ExprNum)
+		ok = isNum(args[1])
+	case ":int":
+		rank := numRank(args[1])
+		ok = (rank == numRankInt) || (rank == numRankBigInt)
+	case ":rat":
+		_, ok = args[1].(*ExprRat)
+	case ":float":
+		_, ok = args[1].(ExprFloat)
 	case ":list":
 		_, ok = args[1].(ExprList)
 	case ":vec":
@@ -158,6 +169,12 @@ func stdIs(args []Expr) (Expr, error) {
 		_, ok = args[1].(ExprErr)
 	case ":atom":
 		_, ok = args[1].(*ExprAtom)
+	case ":go":
+		_, ok = args[1].(ExprGo)
+	case ":chan":
+		_, ok = args[1].(ExprChan)
+	case ":promise":
+		_, ok = args[1].(*ExprPromise)
 	case ":nil":
 		ok = (isEq(exprNil, args[1]))
 	case ":true":
@@ -165,7 +182,7 @@ func stdIs(args []Expr) (Expr, error) {
 	case ":false":
 		ok = (isEq(exprFalse, args[1]))
 	default:
-		return nil, fmt.Errorf("expected not `%s` but one of: `:list`, `:ident`, `:str`, `:num`, `:vec`, `:hashmap`, `:fn`, `:macro`, `:keyword`, `:atom`, `:err`, `:nil`, `:true`, `:false`", kind)
+		return nil, fmt.Errorf("expected not `%s` but one of: `:list`, `:ident`, `:str`, `:num`, `:int`, `:rat`, `:float`, `:vec`, `:hashmap`, `:fn`, `:macro`, `:keyword`, `:atom`, `:go`, `:chan`, `:promise`, `:err`, `:nil`, `:true`, `:false`", kind)
 	}
 	return exprBool(ok), nil
 }
@@ -193,10 +210,15 @@ func stdCount(args []Expr) (Expr, error) {
 }
 
 func stdEq(args []Expr) (Expr, error) {
-	if err := checkArgsCount(2, 2, "`=`", args); err != nil {
+	if err := checkArgsCount(1, -1, "`=`", args); err != nil {
 		return nil, err
 	}
-	return exprBool(isEq(args[0], args[1])), nil
+	for i := 1; i < len(args); i++ {
+		if !isEq(args[i-1], args[i]) {
+			return exprFalse, nil
+		}
+	}
+	return exprTrue, nil
 }
 
 func stdCmp(args []Expr) (Expr, error) {
@@ -207,43 +229,33 @@ func stdCmp(args []Expr) (Expr, error) {
 	return ExprNum(order), nil
 }
 func stdLt(args []Expr) (Expr, error) {
-	order, err := compare(args)
-	if err != nil {
-		return nil, err
-	}
-	return exprBool(order == -1), nil
+	return compareChained("`<`", args, func(order int) bool { return order == -1 })
 }
 func stdLe(args []Expr) (Expr, error) {
-	order, err := compare(args)
-	if err != nil {
-		return nil, err
-	}
-	return exprBool(order <= 0), nil
+	return compareChained("`<=`", args, func(order int) bool { return order <= 0 })
 }
 func stdGt(args []Expr) (Expr, error) {
-	order, err := compare(args)
-	if err != nil {
-		return nil, err
-	}
-	return exprBool(order == 1), nil
+	return compareChained("`>`", args, func(order int) bool { return order == 1 })
 }
 func stdGe(args []Expr) (Expr, error) {
-	order, err := compare(args)
-	if err != nil {
-		return nil, err
-	}
-	return exprBool(order >= 0), nil
+	return compareChained("`>=`", args, func(order int) bool { return order >= 0 })
 }
 
 func stdReadExpr(args []Expr) (Expr, error) {
-	if err := checkArgsCount(1, 1, "`readExpr`", args); err != nil {
+	if err := checkArgsCount(1, 2, "`readExpr`", args); err != nil {
 		return nil, err
 	}
 	src, err := checkIs[ExprStr](args[0])
 	if err != nil {
 		return nil, err
 	}
-	return readExpr(string(src))
+	var src_file_path ExprStr
+	if len(args) > 1 {
+		if src_file_path, err = checkIs[ExprStr](args[1]); err != nil {
+			return nil, err
+		}
+	}
+	return readExpr(string(src_file_path), string(src))
 }
 
 func stdReadTextFile(args []Expr) (Expr, error) {
@@ -261,64 +273,22 @@ func stdReadTextFile(args []Expr) (Expr, error) {
 	return ExprStr(file_bytes), nil
 }
 
-func stdEval(args []Expr) (Expr, error) {
-	if err := checkArgsCount(1, 1, "`eval`", args); err != nil {
+func (me *Interpreter) stdLoadFile(args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 1, "`loadFile`", args); err != nil {
 		return nil, err
 	}
-	return evalAndApply(&envMain, args[0])
-}
-
-func stdAtomFrom(args []Expr) (Expr, error) {
-	if err := checkArgsCount(1, 1, "`atomFrom`", args); err != nil {
-		return nil, err
-	}
-	return &ExprAtom{Ref: args[0]}, nil
-}
-func stdAtomGet(args []Expr) (Expr, error) {
-	if err := checkArgsCount(1, 1, "`atomGet`", args); err != nil {
-		return nil, err
-	}
-	atom, err := checkIs[*ExprAtom](args[0])
-	if err != nil {
-		return nil, err
-	}
-	return atom.Ref, nil
-}
-func stdAtomSet(args []Expr) (Expr, error) {
-	if err := checkArgsCount(2, 2, "`atomSet`", args); err != nil {
-		return nil, err
-	}
-	atom, err := checkIs[*ExprAtom](args[0])
+	src_file_path, err := checkIs[ExprStr](args[0])
 	if err != nil {
 		return nil, err
 	}
-	atom.Ref = args[1]
-	return atom.Ref, nil
+	return exprNil, me.LoadFile(string(src_file_path))
 }
-func stdAtomSwap(args []Expr) (Expr, error) {
-	if err := checkArgsCount(2, -1, "`atomSwap`", args); err != nil {
-		return nil, err
-	}
-	atom, err := checkIs[*ExprAtom](args[0])
-	if err != nil {
-		return nil, err
-	}
-	call_args := append([]Expr{atom.Ref}, args[2:]...)
-	switch fn := args[1].(type) {
-	case *ExprFn:
-		if fakeFuncNamesForDebugging && (fn.nameMaybe == "") {
-			fn.nameMaybe = str(true, append(ExprList{ExprIdent("atomSwap")}, args...))
-		}
-		atom.Ref, err = fn.Call(call_args)
-	case ExprFunc:
-		atom.Ref, err = fn(call_args)
-	default:
-		return nil, newErrNotCallable(fn)
-	}
-	if err != nil {
+
+func (me *Interpreter) stdEval(args []Expr) (Expr, error) {
+	if err := checkArgsCount(1, 1, "`eval`", args); err != nil {
 		return nil, err
 	}
-	return atom.Ref, nil
+	return me.eval(me.Env, args[0])
 }
 
 func stdCons(args []Expr) (Expr, error) {
@@ -361,7 +331,7 @@ func stdVec(args []Expr) (Expr, error) {
 	return (ExprVec)(list), nil
 }
 
-func stdListAt(args []Expr) (Expr, error) {
+func (me *Interpreter) stdListAt(args []Expr) (Expr, error) {
 	err := checkArgsCount(2, 3, "`at`", args)
 	if err != nil {
 		return nil, err
@@ -381,7 +351,7 @@ func stdListAt(args []Expr) (Expr, error) {
 	is_range := (len(args) == 3)
 	err_out_of_range = err_out_of_range || ((!is_range) && (int(idx_start) == len(list)))
 	if err_out_of_range {
-		if malCompat {
+		if me.Options.MalCompat {
 			return exprNil, nil
 		} else {
 			return nil, fmt.Errorf("index %d out of range with list of length %d", idx_start, len(list))
@@ -400,7 +370,7 @@ func stdListAt(args []Expr) (Expr, error) {
 		idx_end = ExprNum(len(list))
 	}
 	if (int(idx_end) > len(list)) || (idx_end < idx_start) {
-		if malCompat {
+		if me.Options.MalCompat {
 			return exprNil, nil
 		} else {
 			return nil, fmt.Errorf("incorrect end index %d with list of length %d and start index %d", idx_end, len(list), idx_start)
@@ -599,7 +569,14 @@ func stdHashmapVals(args []Expr) (Expr, error) {
 	return ret, nil
 }
 
-func stdApply(args []Expr) (Expr, error) {
+func init() {
+	builtinsInEnv[funcAddr(stdApply)] = apply
+}
+
+func stdApply(args []Expr) (Expr, error) { return apply(nil, args) }
+
+// apply is `apply`, which calls the func in `env`.
+func apply(env *Env, args []Expr) (Expr, error) {
 	if err := checkArgsCount(2, -1, "`apply`", args); err != nil {
 		return nil, err
 	}
@@ -609,20 +586,17 @@ func stdApply(args []Expr) (Expr, error) {
 	}
 	args_list := append(args[1:len(args)-1], args_final_list...)
 
-	switch fn := args[0].(type) {
-	case *ExprFn:
-		if fakeFuncNamesForDebugging && (fn.nameMaybe == "") {
-			fn.nameMaybe = str(true, append(ExprList{ExprIdent("apply")}, args...))
-		}
-		return fn.Call(args_list)
-	case ExprFunc:
-		return fn(args_list)
+	if fn, _ := args[0].(*ExprFn); fakeFuncNamesForDebugging && (fn != nil) && (fn.nameMaybe == "") {
+		fn.nameMaybe = str(true, append(ExprList{ExprIdent("apply")}, args...))
 	}
-
-	return nil, newErrNotCallable(args[0])
+	fn, err := callableIn(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return fn(args_list)
 }
 
-func stdReadLine(args []Expr) (Expr, error) {
+func (me *Interpreter) stdReadLine(args []Expr) (Expr, error) {
 	if err := checkArgsCount(1, 1, "`readLine`", args); err != nil {
 		return nil, err
 	}
@@ -630,8 +604,13 @@ func stdReadLine(args []Expr) (Expr, error) {
 	if err != nil {
 		return nil, nil
 	}
-	os.Stdout.Write([]byte(prompt))
-	input, err := readUntil(os.Stdin, '\n', 128)
+	var input string
+	if line_editor := me.lineEditor(); line_editor != nil {
+		input, err = line_editor.ReadLine(string(prompt))
+	} else {
+		_, _ = io.WriteString(me.Stdout, string(prompt))
+		input, err = readUntil(me.Stdin, '\n', 128)
+	}
 	if err == io.EOF {
 		return exprNil, nil
 	} else if err != nil {
@@ -640,6 +619,20 @@ func stdReadLine(args []Expr) (Expr, error) {
 	return ExprStr(input), nil
 }
 
+// ExitErr is the error of a `quit` or `exit` call: `try` does not catch it, so it ends the evaluation, for the host to
+// exit (if at all) with its `Code`.
+type ExitErr struct{ Code int }
+
+func (me *ExitErr) Error() string {
+	return fmt.Sprintf("exit with code %d", me.Code)
+}
+
+// isErrExit tells whether `err` is (or wraps) an `*ExitErr`.
+func isErrExit(err error) bool {
+	var exit_err *ExitErr
+	return errors.As(err, &exit_err)
+}
+
 func stdQuit(args []Expr) (Expr, error) {
 	var exit_code ExprNum
 	if len(args) > 0 {
@@ -648,8 +641,7 @@ func stdQuit(args []Expr) (Expr, error) {
 			exit_code = 255
 		}
 	}
-	os.Exit(int(exit_code))
-	return exprNil, nil
+	return nil, &ExitErr{Code: int(exit_code)}
 }
 
 func stdTimeMs(args []Expr) (Expr, error) {
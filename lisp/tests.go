@@ -0,0 +1,205 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Test is one `(deftest name body...)`, as registered by evaluating it, for `RunTests`.
+type Test struct {
+	Name string
+	Span *SrcSpan // of the first form of its body, if known
+	body Expr
+}
+
+// AssertErr is the error of a failed `assertEq` or `assertThrows`.
+type AssertErr struct {
+	Assertion string
+	Expected  string // as printed by `str(true, ...)`, or else a description
+	Actual    string // as printed by `str(true, ...)`, or else a description
+}
+
+func (me *AssertErr) Error() string {
+	return "`" + me.Assertion + "` failed: expected " + me.Expected + ", but got " + me.Actual
+}
+
+// interpTests are the `Test`s of an `Interpreter`, in the order first defined.
+type interpTests struct {
+	sync.Mutex
+	all []*Test
+}
+
+// stdDefTest is the `(deftest name body...)` special form: it registers (or re-registers) the test `name` for `RunTests`,
+// without evaluating its `body` forms.
+func stdDefTest(env *Env, args []Expr) (*Env, Expr, error) {
+	if err := checkArgsCount(2, -1, "`deftest`", args); err != nil {
+		return nil, nil, err
+	}
+	var name string
+	switch it := args[0].(type) {
+	case ExprIdent:
+		name = string(it)
+	case ExprStr:
+		name = string(it)
+	default:
+		return nil, nil, fmt.Errorf("expected an identifier or a string as the name in `deftest`, instead of `%s`", str(true, args[0]))
+	}
+	test := &Test{Name: name, body: append(ExprList{exprIdentDo}, args[1:]...)}
+	for _, form := range args[1:] {
+		if test.Span = srcSpanOf(form); test.Span != nil {
+			break
+		}
+	}
+
+	tests := &env.interp.tests
+	tests.Lock()
+	defer tests.Unlock()
+	if idx := slices.IndexFunc(tests.all, func(it *Test) bool { return it.Name == name }); idx >= 0 {
+		tests.all[idx] = test
+	} else {
+		tests.all = append(tests.all, test)
+	}
+	return nil, exprNil, nil
+}
+
+// stdAssertThrows is the `(assertThrows expr)` special form, or `(assertThrows expr expected)`: it fails unless evaluating
+// `expr` fails, with an error that, as caught by `try`, equals `expected` (if given). else it returns the error as caught.
+func stdAssertThrows(env *Env, args []Expr) (*Env, Expr, error) {
+	if err := checkArgsCount(1, 2, "`assertThrows`", args); err != nil {
+		return nil, nil, err
+	}
+	var expected Expr
+	if len(args) > 1 {
+		var err error
+		if expected, err = evalAndApply(env, args[1]); err != nil {
+			return nil, nil, err
+		}
+	}
+	expected_str := "an error"
+	if expected != nil {
+		expected_str += " of " + str(true, expected)
+	}
+	expr, err := evalAndApply(env, args[0])
+	if err == nil {
+		return nil, nil, &AssertErr{Assertion: "assertThrows", Expected: expected_str, Actual: "no error but " + str(true, expr)}
+	} else if err_canceled := errIfCanceled(env.context()); err_canceled != nil { // then `err` was (most likely) due to that
+		return nil, nil, err_canceled
+	} else if isErrExit(err) {
+		return nil, nil, err
+	}
+	thrown := errExpr(err)
+	if (expected != nil) && !isEq(expected, thrown) {
+		return nil, nil, &AssertErr{Assertion: "assertThrows", Expected: expected_str, Actual: "an error of " + str(true, thrown)}
+	}
+	return nil, thrown, nil
+}
+
+func stdAssertEq(args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, 2, "`assertEq`", args); err != nil {
+		return nil, err
+	}
+	if !isEq(args[0], args[1]) {
+		return nil, &AssertErr{Assertion: "assertEq", Expected: str(true, args[0]), Actual: str(true, args[1])}
+	}
+	return exprTrue, nil
+}
+
+// Tests returns all the `deftest`s evaluated so far, in the order first defined.
+func (me *Interpreter) Tests() []*Test {
+	me.tests.Lock()
+	defer me.tests.Unlock()
+	return slices.Clone(me.tests.all)
+}
+
+// RunTests runs all `Tests`, each in a fresh child env of `me.Env`, writing one `--- PASS` or `--- FAIL` line per test to `w`
+// (followed, for a failure, by its error with the expected and actual values if from an assertion). it returns the number of failures.
+func (me *Interpreter) RunTests(w io.Writer) (numFailed int) {
+	for _, test := range me.Tests() {
+		start := time.Now()
+		_, err := me.evalContextIn(context.Background(), newEnv(me.Env, nil, nil), test.body)
+		took := time.Since(start).Round(time.Microsecond)
+		if err == nil {
+			fmt.Fprintf(w, "--- PASS: %s (%s)\n", test.Name, took)
+			continue
+		}
+		numFailed++
+		fmt.Fprintf(w, "--- FAIL: %s (%s)\n", test.Name, took)
+		var assert_err *AssertErr
+		if !errors.As(err, &assert_err) {
+			fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(ErrStackTrace(err), "\n", "\n    "))
+			continue
+		}
+		msg := "`" + assert_err.Assertion + "` failed"
+		var src_err *SrcErr
+		if errors.As(err, &src_err) {
+			msg = src_err.Span.String() + ": " + msg
+		}
+		fmt.Fprintf(w, "    %s\n        expected: %s\n          actual: %s\n", msg, assert_err.Expected, assert_err.Actual)
+		if _, stack, has_stack := strings.Cut(ErrStackTrace(err), "\n"); has_stack {
+			fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(stack, "\n", "\n    "))
+		}
+	}
+	return
+}
+
+// FindTestFiles returns the `*_test.lisp` files in `paths` (recursively for directories), or in the current directory if none.
+func FindTestFiles(paths ...string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var ret []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			ret = append(ret, path)
+			continue
+		}
+		err := filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+			if (err == nil) && (!entry.IsDir()) && strings.HasSuffix(entry.Name(), "_test.lisp") {
+				ret = append(ret, filePath)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// RunTestFiles runs the `FindTestFiles` of `paths`, each by loading it into its own new `Interpreter` (with `options`)
+// and then `RunTests`. it writes the results of each file to `w`, and returns the number of tests that failed (counting any file that failed to load as one).
+func RunTestFiles(options Options, w io.Writer, paths ...string) (numFailed int, err error) {
+	file_paths, err := FindTestFiles(paths...)
+	if err != nil {
+		return 0, err
+	}
+	var num_tests int
+	for _, file_path := range file_paths {
+		fmt.Fprintf(w, "=== %s\n", file_path)
+		interp := NewInterpreter(options)
+		if err := interp.LoadFile(file_path); err != nil {
+			num_tests, numFailed = num_tests+1, numFailed+1
+			fmt.Fprintf(w, "--- FAIL: loading %s\n    %s\n", file_path, strings.ReplaceAll(ErrStackTrace(err), "\n", "\n    "))
+			continue
+		}
+		num_tests += len(interp.Tests())
+		numFailed += interp.RunTests(w)
+	}
+	if numFailed > 0 {
+		fmt.Fprintf(w, "FAIL: %d of %d tests failed (in %d files)\n", numFailed, num_tests, len(file_paths))
+	} else {
+		fmt.Fprintf(w, "PASS: %d tests (in %d files)\n", num_tests, len(file_paths))
+	}
+	return numFailed, nil
+}
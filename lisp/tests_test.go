@@ -0,0 +1,108 @@
+package lisp
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const srcTestTests = `
+(deftest "adds" (assertEq 3 (+ 1 2)))
+(deftest fails (assertEq 4 (+ 1 2)))
+(deftest throws (assertThrows (throw "x") "x"))
+(deftest noThrow (assertThrows (+ 1 2)))
+(deftest wrongThrow (assertThrows (throw 1) 2))
+`
+
+// testTestsOutput is the output of `RunTests` of `srcTestTests`, loaded from `filePath`.
+func testTestsOutput(filePath string) string {
+	return strings.ReplaceAll(`--- PASS: adds
+--- FAIL: fails
+    FILE:3:16: `+"`assertEq`"+` failed
+        expected: 4
+          actual: 3
+        at `+"`assertEq`"+` (FILE:3:16)
+--- PASS: throws
+--- FAIL: noThrow
+    FILE:5:18: `+"`assertThrows`"+` failed
+        expected: an error
+          actual: no error but 3
+--- FAIL: wrongThrow
+    FILE:6:21: `+"`assertThrows`"+` failed
+        expected: an error of 2
+          actual: an error of 1
+`, "FILE", filePath)
+}
+
+// testTestsDurations matches the durations in the `--- PASS` and `--- FAIL` lines of `RunTests`.
+var testTestsDurations = regexp.MustCompile(`(?m)^(--- (?:PASS|FAIL): \S+) \([^)]*\)$`)
+
+func TestRunTests(t *testing.T) {
+	interp := NewInterpreter(Options{})
+	if err := interp.Load("tests.lisp", strings.NewReader(srcTestTests)); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, test := range interp.Tests() {
+		names = append(names, test.Name)
+	}
+	if have := strings.Join(names, " "); have != "adds fails throws noThrow wrongThrow" {
+		t.Errorf("unexpected tests: %s", have)
+	}
+	var output strings.Builder
+	if num_failed := interp.RunTests(&output); num_failed != 3 {
+		t.Errorf("expected 3 failures, got %d", num_failed)
+	}
+	if have, want := testTestsDurations.ReplaceAllString(output.String(), "$1"), testTestsOutput("tests.lisp"); have != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, have)
+	}
+
+	testEvalAll(t, interp, [][2]string{
+		{"(assertThrows (throw :oops))", ":oops"},
+		{"(assertThrows (throw :oops) :oops)", ":oops"},
+		{"(assertThrows :fine)", "error: 1:1: `assertThrows` failed: expected an error, but got no error but :fine"},
+		{"(assertEq (list 1 2) (list 1 2))", ":true"},
+	})
+}
+
+// TestRunTestsSandboxed checks that `RunTests` runs each test as its own top-level evaluation, with the `Sandbox` limits of one.
+func TestRunTestsSandboxed(t *testing.T) {
+	interp := NewInterpreter(Options{Sandbox: &Sandbox{MaxWallTime: 50 * time.Millisecond}})
+	if err := interp.Load("", strings.NewReader("(deftest spins (def spin (fn () (spin))) (spin))")); err != nil {
+		t.Fatal(err)
+	}
+	var output strings.Builder
+	if num_failed := interp.RunTests(&output); (num_failed != 1) || !strings.Contains(output.String(), "sandbox limit exceeded: MaxWallTime") {
+		t.Errorf("expected the test to fail on the MaxWallTime, got:\n%s", output.String())
+	}
+}
+
+func TestRunTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{"a_test.lisp": srcTestTests, "b_test.lisp": "(deftest b (assertEq 1 1))", "c_test.lisp": "(", "d.lisp": "(deftest d (assertEq 1 2))"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var output strings.Builder
+	num_failed, err := RunTestFiles(Options{}, &output, dir)
+	if err != nil {
+		t.Fatal(err)
+	} else if num_failed != 4 { // 3 in `a_test.lisp`, and the failed loading of `c_test.lisp`
+		t.Errorf("expected 4 failures, got %d", num_failed)
+	}
+	have := testTestsDurations.ReplaceAllString(output.String(), "$1")
+	for _, want := range []string{
+		"=== " + filepath.Join(dir, "a_test.lisp") + "\n" + testTestsOutput(filepath.Join(dir, "a_test.lisp")),
+		"=== " + filepath.Join(dir, "b_test.lisp") + "\n--- PASS: b\n",
+		"=== " + filepath.Join(dir, "c_test.lisp") + "\n--- FAIL: loading " + filepath.Join(dir, "c_test.lisp") + "\n",
+		"FAIL: 4 of 7 tests failed (in 3 files)\n",
+	} {
+		if !strings.Contains(have, want) {
+			t.Errorf("expected in the output:\n%s\ngot:\n%s", want, have)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package lisp
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Tracer logs calls to (and returns from) the functions it has been told to trace by name, or to all of them for the name `*`.
+// with calls from several goroutines, their lines interleave and the nesting depth is shared.
+type Tracer struct {
+	Out  io.Writer // if `nil`, the `Stderr` of the `Interpreter`
+	JSON bool      // if `false`, the output is indented by call nesting depth instead of being JSON lines
+
+	interp *Interpreter
+
+	mutex sync.Mutex  // for all the below
+	on    atomic.Bool // whether `names` is non-empty, so that the common untraced case needs no locking
+	names map[string]bool
+	depth int
+}
+
+func (me *Tracer) isTraced(name string) bool {
+	if !me.on.Load() {
+		return false
+	}
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	return me.names[name] || me.names["*"]
+}
+
+// Set starts (or, if not `on`, stops) tracing calls to the functions of the specified `names`.
+func (me *Tracer) Set(on bool, names ...string) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	for _, name := range names {
+		if on {
+			me.names[name] = true
+		} else {
+			delete(me.names, name)
+		}
+	}
+	me.on.Store(len(me.names) > 0)
+}
+
+// tracedNames returns the names of the functions traced now, sorted.
+func (me *Tracer) tracedNames() []string {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ret := make([]string, 0, len(me.names))
+	for name := range me.names {
+		ret = append(ret, name)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func (me *Tracer) call(name string, args []Expr) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if me.JSON {
+		me.writeJSON(map[string]any{"event": "call", "depth": me.depth, "fn": name, "args": strs(args)})
+	} else {
+		me.writeIndented("> " + str(true, append(ExprList{ExprIdent(name)}, args...)))
+	}
+	me.depth++
+}
+
+func (me *Tracer) ret(name string, result Expr, err error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.depth--
+	switch {
+	case me.JSON && (err != nil):
+		me.writeJSON(map[string]any{"event": "error", "depth": me.depth, "fn": name, "error": err.Error()})
+	case me.JSON:
+		me.writeJSON(map[string]any{"event": "return", "depth": me.depth, "fn": name, "result": str(true, result)})
+	case err != nil:
+		me.writeIndented("! " + err.Error())
+	default:
+		me.writeIndented("< " + str(true, result))
+	}
+}
+
+func (me *Tracer) writeIndented(line string) {
+	_, _ = io.WriteString(me.out(), strings.Repeat("  ", me.depth)+line+"\n")
+}
+
+func (me *Tracer) writeJSON(event map[string]any) {
+	json_line, _ := json.Marshal(event)
+	_, _ = me.out().Write(append(json_line, '\n'))
+}
+
+func (me *Tracer) out() io.Writer {
+	if me.Out == nil {
+		return me.interp.Stderr
+	}
+	return me.Out
+}
+
+func strs(exprs []Expr) []string {
+	ret := make([]string, len(exprs))
+	for i, expr := range exprs {
+		ret[i] = str(true, expr)
+	}
+	return ret
+}
+
+// stdTraceSet backs the `trace` and `untrace` macros: `(traceSet :true (foo bar))` starts tracing calls to `foo` and `bar`.
+// `untrace` without any names stops all tracing. returns the names traced now.
+func (me *Interpreter) stdTraceSet(args []Expr) (Expr, error) {
+	if err := checkArgsCount(2, 2, "`traceSet`", args); err != nil {
+		return nil, err
+	}
+	names, err := checkIsSeq(args[1])
+	if err != nil {
+		return nil, err
+	}
+	on := !isNilOrFalse(args[0])
+	if (!on) && (len(names) == 0) {
+		me.Tracer.Set(false, me.Tracer.tracedNames()...)
+	}
+	for _, name := range names {
+		ident, err := checkIs[ExprIdent](name)
+		if err != nil {
+			return nil, err
+		}
+		me.Tracer.Set(on, string(ident))
+	}
+
+	ret := ExprList{}
+	for _, name := range me.Tracer.tracedNames() {
+		ret = append(ret, ExprIdent(name))
+	}
+	return ret, nil
+}
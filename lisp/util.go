@@ -1,9 +1,8 @@
-package main
+package lisp
 
 import (
 	"fmt"
 	"io"
-	"os"
 )
 
 func isNilOrFalse(expr Expr) bool {
@@ -57,23 +56,6 @@ func checkAre[T Expr](have ...Expr) error {
 	return nil
 }
 
-func checkAreBoth[T1 Expr, T2 Expr](have []Expr, exactArgsCount bool) (ret1 T1, ret2 T2, err error) {
-	max_args_count := -1
-	if exactArgsCount {
-		max_args_count = 2
-	}
-	if err = checkArgsCount(2, max_args_count, "operator", have); err != nil {
-		return
-	}
-	if ret1, err = checkIs[T1](have[0]); err != nil {
-		return
-	}
-	if ret2, err = checkIs[T2](have[1]); err != nil {
-		return
-	}
-	return
-}
-
 func checkIsStrOrKeyword(expr Expr) (string, Expr, error) {
 	switch it := expr.(type) {
 	case ExprStr:
@@ -95,16 +77,43 @@ func checkIsSeq(expr Expr) ([]Expr, error) {
 	}
 }
 
+// checkIsCallable returns `expr` (if an `*ExprFn` then its `Call` method) as an `ExprFunc`.
+func checkIsCallable(expr Expr) (ExprFunc, error) {
+	switch it := expr.(type) {
+	case *ExprFn:
+		return it.Call, nil
+	case ExprFunc:
+		return it, nil
+	}
+	return nil, newErrNotCallable(expr)
+}
+
+// callableIn is `checkIsCallable` for the Go builtins in `builtinsInEnv`, for calling `expr` in the `Env` they are called in
+// (unless `nil`): an `*ExprFn` via its `callIn`, and a builtin via its variant in `builtinsInEnv` (if any).
+func callableIn(env *Env, expr Expr) (ExprFunc, error) {
+	switch it := expr.(type) {
+	case *ExprFn:
+		if env != nil {
+			return func(args []Expr) (Expr, error) { return it.callIn(env, args) }, nil
+		}
+	case ExprFunc:
+		if in_env := builtinsInEnv[funcAddr(it)]; (env != nil) && (in_env != nil) {
+			return func(args []Expr) (Expr, error) { return in_env(env, args) }, nil
+		}
+	}
+	return checkIsCallable(expr)
+}
+
 func newErrNotCallable(expr Expr) error {
 	return fmt.Errorf("not callable: `%s`", str(true, expr))
 }
 
-func addOsArgsToEnv() {
-	if len(os.Args) > 1 {
-		args := make(ExprList, 0, len(os.Args)-2)
-		for _, arg := range os.Args[2:] {
-			args = append(args, ExprStr(arg))
+// recovering returns the result of `fn`, or an error if it panics: for the goroutines started by builtins, where a panic would crash the whole program.
+func recovering(fn func() (Expr, error)) (ret Expr, err error) {
+	defer func() {
+		if it := recover(); it != nil {
+			ret, err = nil, fmt.Errorf("panic: %v", it)
 		}
-		envMain.set("osArgs", args)
-	}
+	}()
+	return fn()
 }
@@ -0,0 +1,370 @@
+package lisp
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+)
+
+// vm is the state of one `vmRun`.
+type vm struct {
+	interp  *Interpreter
+	ctx     context.Context // that of the `Env` that `vmRun` began in: like in `evalAndApply`, all calls get their caller's
+	stack   []Expr          // the values of all frames
+	frames  []vmFrame
+	catches []vmCatch
+}
+
+// vmFrame is a call of (or other run of) some bytecode, in progress.
+type vmFrame struct {
+	proto  *vmProto
+	pc     int
+	env    *Env
+	base   int         // the length of `vm.stack` when the frame began
+	depth  int         // only tracked for `Sandbox.MaxDepth`
+	fn     *StackFrame // the `*ExprFn` call the frame is for, if any: as in `evalAndApply`, tail calls replace it
+	traced []string    // as in `evalAndApply`, the names of all traced `*ExprFn`s (tail-)called in the frame
+	prof   *profFrame  // the `Profiler`'s record of `fn`, if any
+	site   *vmSite     // the call that the frame is waiting on, if any
+}
+
+// vmCatch is a `catch` set up by `vmOpTry`.
+type vmCatch struct {
+	frame int // the index in `vm.frames` of the frame that set it up
+	stack int // the length of `vm.stack` when set up
+	env   *Env
+	pc    int
+	catch []Expr
+}
+
+// vmRun runs the bytecode `proto` in `env` until it returns. like `evalAndApply`, it makes proper tail calls (of all
+// `*ExprFn`s whose bodies are in bytecode), but without growing the Go stack for their non-tail calls either.
+func vmRun(proto *vmProto, env *Env) (Expr, error) {
+	me := vm{interp: env.interp, ctx: env.context(), stack: make([]Expr, 0, 16), frames: make([]vmFrame, 1, 8)}
+	me.frames[0] = vmFrame{proto: proto, env: env}
+	if err := errIfCanceled(me.ctx); err != nil {
+		return nil, err
+	} else if me.interp.sandbox != nil {
+		if err = me.interp.sandboxStep(me.ctx); err != nil {
+			return nil, err
+		} else if me.frames[0].depth, err = me.interp.sandboxDepth(env); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		ret, err := me.run()
+		if err == nil {
+			return ret, nil
+		} else if err = me.unwind(err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// run runs instructions until the outermost frame returns or any instruction fails.
+func (me *vm) run() (Expr, error) {
+	frame := &me.frames[len(me.frames)-1]
+	for {
+		proto, pc := frame.proto, frame.pc
+		op := vmOp(proto.code[pc])
+		frame.pc += 1 + 2*vmOpsNumOperands[op]
+		switch op {
+		case vmOpConst:
+			me.push(proto.consts[proto.operand(pc, 0)])
+		case vmOpSlot:
+			env := frame.env
+			for range proto.operand(pc, 0) {
+				env = env.Parent
+			}
+			if found := env.slots[proto.operand(pc, 1)].Load(); found != nil {
+				me.push(*found)
+			} else if err := me.pushLookup(env.Parent, &proto.sites[proto.operand(pc, 2)]); err != nil { // a `let` binding referred to by an earlier one (or by itself)
+				return nil, err
+			}
+		case vmOpGlobal:
+			if found := proto.globals[proto.operand(pc, 0)].Load(); found != nil {
+				me.push(*found)
+			} else if err := me.pushLookup(frame.env, &proto.sites[proto.operand(pc, 1)]); err != nil { // for the error, or for a new entry added to the root `Env.Map` directly
+				return nil, err
+			}
+		case vmOpLookup:
+			if err := me.pushLookup(frame.env, &proto.sites[proto.operand(pc, 0)]); err != nil {
+				return nil, err
+			}
+		case vmOpPop:
+			me.pop()
+		case vmOpJump:
+			frame.pc = proto.operand(pc, 0)
+		case vmOpJumpIfNot:
+			if isNilOrFalse(me.pop()) {
+				frame.pc = proto.operand(pc, 0)
+			}
+		case vmOpMacroCheck:
+			if fn, _ := me.stack[len(me.stack)-1].(*ExprFn); (fn != nil) && fn.isMacro {
+				site := &proto.sites[proto.operand(pc, 0)]
+				expansion, err := macroExpand(frame.env, site.form)
+				if err != nil {
+					return nil, errAtSpan(site.span, err)
+				}
+				me.pop()
+				expanded := (&compiler{env: frame.env}).assemble(expansion, site.span)
+				if site.tail {
+					frame.proto, frame.pc = expanded, 0
+				} else {
+					depth, err := me.nextDepth()
+					if err != nil {
+						return nil, err
+					}
+					frame.pc, frame.site = proto.operand(pc, 1), site
+					frame = me.enter(vmFrame{proto: expanded, env: frame.env, depth: depth})
+				}
+			}
+		case vmOpCall, vmOpTailCall:
+			is_tail := (op == vmOpTailCall)
+			entered, err := me.call(frame, &proto.sites[proto.operand(pc, 0)], is_tail)
+			if err != nil {
+				return nil, err
+			} else if entered {
+				frame = &me.frames[len(me.frames)-1]
+			} else if is_tail {
+				if ret, done := me.leave(); done {
+					return ret, nil
+				}
+				frame = &me.frames[len(me.frames)-1]
+			}
+		case vmOpReturn:
+			if ret, done := me.leave(); done {
+				return ret, nil
+			}
+			frame = &me.frames[len(me.frames)-1]
+		case vmOpClosure:
+			it := *proto.consts[proto.operand(pc, 0)].(*ExprFn) // so `it.code` is shared by all `it`s, compiled on the first call of any one of them
+			it.env = fnEnv(frame.env)
+			if me.interp.Options.NoTco && !it.isMacro {
+				me.push((ExprFunc)(it.Call))
+			} else {
+				me.push(&it)
+			}
+		case vmOpDefCheck:
+			site := &proto.sites[proto.operand(pc, 0)]
+			if _, err := defOrSetName(site.isDef, frame.env, site.form[1:]); err != nil {
+				return nil, errAtSpan(site.span, err)
+			}
+		case vmOpDef:
+			site := &proto.sites[proto.operand(pc, 0)]
+			defBind(frame.env, site.form[1].(ExprIdent), site.form[2], me.stack[len(me.stack)-1])
+		case vmOpLet:
+			frame.env = newSlotsEnv(frame.env, proto.consts[proto.operand(pc, 0)].(ExprList), nil)
+		case vmOpLetBind:
+			value := me.pop()
+			frame.env.slots[proto.operand(pc, 0)].Store(&value)
+		case vmOpLetEnd:
+			frame.env = frame.env.Parent
+		case vmOpVec:
+			num_items := proto.operand(pc, 0)
+			vec := slices.Clone(ExprVec(me.stack[len(me.stack)-num_items:]))
+			me.stack = me.stack[:len(me.stack)-num_items]
+			if me.interp.sandbox != nil {
+				if err := me.interp.sandboxAlloc(me.ctx, vec); err != nil {
+					return nil, err
+				}
+			}
+			me.push(vec)
+		case vmOpHashMap:
+			keys := proto.consts[proto.operand(pc, 0)].(ExprList)
+			hash_map, values := make(ExprHashMap, len(keys)), me.stack[len(me.stack)-len(keys):]
+			for i, key := range keys {
+				hash_map[string(key.(ExprStr))] = values[i]
+			}
+			me.stack = me.stack[:len(me.stack)-len(keys)]
+			if me.interp.sandbox != nil {
+				if err := me.interp.sandboxAlloc(me.ctx, hash_map); err != nil {
+					return nil, err
+				}
+			}
+			me.push(hash_map)
+		case vmOpTry:
+			site := &proto.sites[proto.operand(pc, 0)]
+			me.catches = append(me.catches, vmCatch{frame: len(me.frames) - 1, stack: len(me.stack), env: frame.env, pc: proto.operand(pc, 1), catch: site.catch})
+		case vmOpTryEnd:
+			me.catches = me.catches[:len(me.catches)-1]
+		case vmOpSpecial:
+			site := &proto.sites[proto.operand(pc, 0)]
+			tail_env, expr, err := site.sf(frame.env, site.form[1:])
+			if err != nil {
+				return nil, errAtSpan(site.span, err)
+			} else if tail_env != nil {
+				if expr, err = evalAndApply(tail_env, expr); err != nil {
+					return nil, err
+				}
+			}
+			me.push(expr)
+		case vmOpFail:
+			return nil, proto.consts[proto.operand(pc, 0)].(ExprErr).It.(error)
+		}
+	}
+}
+
+func (me *vm) push(expr Expr) {
+	me.stack = append(me.stack, expr)
+}
+
+func (me *vm) pop() (ret Expr) {
+	ret = me.stack[len(me.stack)-1]
+	me.stack = me.stack[:len(me.stack)-1]
+	return
+}
+
+func (me *vm) pushLookup(env *Env, site *vmSite) error {
+	found, err := env.get(site.name)
+	if err != nil {
+		return errAtSpan(site.span, err)
+	}
+	me.push(found)
+	return nil
+}
+
+// call makes the call at `site` in `frame`, the current one. if the callee's body is in bytecode, it tells that it
+// `entered` the new frame for it (or if `isTail`, replaced `frame` by it). else, it pushes the result onto the stack.
+func (me *vm) call(frame *vmFrame, site *vmSite, isTail bool) (entered bool, err error) {
+	num_args := len(site.form) - 1
+	args := slices.Clone(me.stack[len(me.stack)-num_args:]) // as the `Env` of an `*ExprFn` call refers into it
+	callee := me.stack[len(me.stack)-num_args-1]
+	me.stack = me.stack[:len(me.stack)-num_args-1]
+
+	switch fn := callee.(type) {
+	default:
+		return false, errAtSpan(site.span, newErrNotCallable(callee))
+	case ExprFunc:
+		ret, err := me.interp.callFunc(frame.env, fn, &tailCall{callee: fn, args: args, name: string(site.name), span: site.span})
+		if err != nil {
+			return false, err
+		}
+		me.push(ret)
+		return false, nil
+	case *ExprFn:
+		if err = errIfCanceled(me.ctx); err != nil { // like `evalAndApply` for compiled code, checking only once per `*ExprFn` call
+			return false, err
+		} else if me.interp.sandbox != nil {
+			if err = me.interp.sandboxStep(me.ctx); err != nil {
+				return false, err
+			}
+		}
+		body := fn.compiled()
+		in_vm, depth := fn.code.bytecode && (body != nil), frame.depth // if not `in_vm`, the body is interpreted or compiled to Go closures, or its bytecode is still being compiled
+		if in_vm && !isTail {
+			if depth, err = me.nextDepth(); err != nil {
+				return false, err
+			}
+		}
+		stack_frame := &StackFrame{Name: cmp.Or(strings.Trim(fn.nameMaybe, "`"), string(site.name)), Span: site.span}
+		var traced []string
+		if name := stack_frame.Name; me.interp.Tracer.isTraced(name) {
+			me.interp.Tracer.call(name, args)
+			traced = append(traced, name)
+		}
+		var prof, tail_of *profFrame
+		if in_vm && isTail {
+			tail_of, frame.prof = frame.prof, nil
+		}
+		if (tail_of != nil) || me.interp.Profiler.on.Load() {
+			prof = me.interp.Profiler.call(fn, stack_frame.Name, frame.env, tail_of)
+		}
+		env, err := fn.envWith(args)
+		if err != nil {
+			return false, me.callFailed(traced, prof, stack_frame, errAtSpan(site.span, err))
+		}
+		env.ctx, env.depth, env.prof = me.ctx, depth, prof
+		if !in_vm {
+			ret, err := body.evalOr(env, fn.body)
+			if err != nil {
+				return false, me.callFailed(traced, prof, stack_frame, err)
+			}
+			me.traceRets(traced, ret, nil)
+			me.interp.Profiler.ret(prof)
+			me.push(ret)
+			return false, nil
+		}
+		if isTail {
+			frame.proto, frame.pc, frame.env, frame.fn, frame.traced, frame.prof = fn.code.proto, 0, env, stack_frame, append(frame.traced, traced...), prof
+		} else {
+			frame.site = site
+			me.enter(vmFrame{proto: fn.code.proto, env: env, depth: depth, fn: stack_frame, traced: traced, prof: prof})
+		}
+		return true, nil
+	}
+}
+
+func (me *vm) callFailed(traced []string, prof *profFrame, frame *StackFrame, err error) error {
+	me.traceRets(traced, nil, err)
+	me.interp.Profiler.ret(prof)
+	return errWithFrame(err, *frame)
+}
+
+// nextDepth returns the `depth` for a frame to enter on top of the current one, or an error if that goes over `Sandbox.MaxDepth`.
+func (me *vm) nextDepth() (int, error) {
+	if me.interp.sandbox == nil {
+		return 0, nil
+	}
+	depth := me.frames[len(me.frames)-1].depth + 1
+	if max := me.interp.Options.Sandbox.MaxDepth; (max > 0) && (depth > max) {
+		return 0, &SandboxErr{Limit: "MaxDepth", Max: max}
+	}
+	return depth, nil
+}
+
+// enter begins `frame` on top of the current one, and returns it.
+func (me *vm) enter(frame vmFrame) *vmFrame {
+	frame.base = len(me.stack)
+	me.frames = append(me.frames, frame)
+	return &me.frames[len(me.frames)-1]
+}
+
+// leave ends the current frame, whose result is on top of the stack. unless that was the outermost frame, it
+// pushes that result onto the stack of the caller. else it returns it, and that it is `done`.
+func (me *vm) leave() (ret Expr, done bool) {
+	frame := &me.frames[len(me.frames)-1]
+	ret = me.stack[len(me.stack)-1]
+	me.traceRets(frame.traced, ret, nil)
+	me.interp.Profiler.ret(frame.prof)
+	me.stack, me.frames = me.stack[:frame.base], me.frames[:len(me.frames)-1]
+	if len(me.frames) == 0 {
+		return ret, true
+	}
+	me.push(ret)
+	return nil, false
+}
+
+// unwind handles `err` in the current frame: if that set up a `catch` (and `err` is not an `*ExitErr`), it continues there. else it ends the frame
+// and likewise handles `err` in the caller. once it has ended the outermost frame too, it returns the final `err`.
+func (me *vm) unwind(err error) error {
+	for {
+		frame := &me.frames[len(me.frames)-1]
+		if num_catches := len(me.catches); (num_catches > 0) && (me.catches[num_catches-1].frame == len(me.frames)-1) && !isErrExit(err) {
+			catch := me.catches[num_catches-1]
+			me.catches, me.stack = me.catches[:num_catches-1], me.stack[:catch.stack]
+			frame.env, frame.pc = catch.env, catch.pc
+			tryCatchBind(catch.env, catch.catch, err)
+			return nil
+		}
+		me.traceRets(frame.traced, nil, err)
+		me.interp.Profiler.ret(frame.prof)
+		if frame.fn != nil {
+			err = errWithFrame(err, *frame.fn)
+		}
+		me.stack, me.frames = me.stack[:frame.base], me.frames[:len(me.frames)-1]
+		if len(me.frames) == 0 {
+			return err
+		} else if site := me.frames[len(me.frames)-1].site; site != nil {
+			err = errAtSpan(site.span, err)
+		}
+	}
+}
+
+// traceRets logs the returns of all `traced` calls, innermost first.
+func (me *vm) traceRets(traced []string, ret Expr, err error) {
+	for i := len(traced) - 1; i >= 0; i-- {
+		me.interp.Tracer.ret(traced[i], ret, err)
+	}
+}
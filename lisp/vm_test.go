@@ -0,0 +1,46 @@
+package lisp
+
+import (
+	"testing"
+	"time"
+)
+
+// TestVM checks the bytecode VM (`Options.Bytecode`) against the same expectations as the default Go-closures compilation, for
+// the parts of the semantics of `evalAndApply` that it implements anew: `try`/`catch`, tail calls, and macro expansion at run time.
+func TestVM(t *testing.T) {
+	for _, bytecode := range []bool{false, true} {
+		// with a `MaxDepth` far below the recursion depths below, for these to fail if tail calls grew the frames
+		interp := NewInterpreter(Options{Bytecode: bytecode, Sandbox: &Sandbox{MaxDepth: 50, MaxWallTime: time.Minute}})
+		for _, src := range []string{
+			"(def sum (fn (n acc) (if (= n 0) acc (sum (- n 1) (+ acc n)))))",
+			"(def isEven (fn (n) (if (= n 0) :true (isOdd (- n 1)))))",
+			"(def isOdd (fn (n) (if (= n 0) :false (isEven (- n 1)))))",
+			"(def thrower (fn (it) (throw it)))",
+			"(def safeDiv (fn (a b) (try (if (= b 0) (thrower :divByZero) (/ a b)) (catch err (list :caught err)))))",
+			"(def retry (fn (n) (try (if (= n 0) :gaveUp (thrower n)) (catch err (retry (- err 1))))))",
+			"(def late (fn (x) (double x)))", // `double` is not (yet) a macro here, so is expanded only once `late` is called
+			"(def double (macro (x) ´(+ ~x ~x)))",
+			"(def unlessZero (macro (n then) ´(if (= ~n 0) :zero ~then)))",
+			"(def loopDown (fn (n) (unlessZero n (loopDown (- n 1)))))",
+		} {
+			if _, err := interp.ReadAndEval("", src); err != nil {
+				t.Fatal(err)
+			}
+		}
+		testEvalAll(t, interp, [][2]string{
+			{"(sum 10000 0)", "50005000"},
+			{"(isEven 10001)", ":false"},
+			{"(try (throw 42) (catch err err))", "42"},
+			{"(try (thrower (list 1 2)) (catch err (at err 1)))", "2"},
+			{"(safeDiv 10 2)", "5"},
+			{"(safeDiv 10 0)", "(:caught :divByZero)"},
+			{"(try (sum :nope 1) (catch err (is :err err)))", ":true"},
+			{"(try (try (thrower 1) (catch err (thrower (+ err 1)))) (catch err (+ err 1)))", "3"},
+			{"(retry 10000)", ":gaveUp"}, // tail calls from within `catch`
+			{"(thrower 1)", "error: 1:23: 1"},
+			{"(late 21)", "42"},
+			{"(macroExpand (double 1))", "(+ 1 1)"},
+			{"(loopDown 10000)", ":zero"}, // tail calls from macro expansions
+		})
+	}
+}
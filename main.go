@@ -1,122 +1,174 @@
 package main
 
 import (
-	"bufio"
+	"cmp"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/metaleap/go-lisp/lisp"
 )
 
 func main() {
-	// load in the mini-stdlib
-	if !disableTcoFuncs {
-		if _, err := readAndEval("(" + string(exprIdentDo) + " " + srcMiniStdlibMacros + "\n" + string(exprNil) + ")"); err != nil {
-			panic(err)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [srcFilePath|- [osArgs...]]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "   or: %s [flags] test [dirOrFilePath...]  (runs the deftests in all *_test.lisp files found, by default in .)\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "   or: %s [flags] maltest [dirPath]  (runs the mal step test files in it, by default in mal/tests)\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	trace_names := flag.String("trace", "", "comma-separated names of functions to log all calls of, or * for all functions")
+	trace_out := flag.String("trace-out", "", "file to write call traces to, instead of stderr")
+	trace_json := flag.Bool("trace-json", false, "write call traces as JSON lines, instead of indented by call nesting depth")
+	debug := flag.Bool("debug", false, "run the source file in the debugger, pausing at its first form (or with -break, at the first breakpoint)")
+	breaks := flag.String("break", "", "comma-separated debugger breakpoints: function names, or source lines as [file:]line (default file: the source file)")
+	prof := flag.Bool("prof", false, "profile calls of functions, and on exit print a table of their call counts and inclusive and exclusive times to stderr")
+	prof_out := flag.String("prof-out", "", "profile calls of functions, and on exit write a pprof profile of them to this file (for go tool pprof)")
+	cover := flag.Bool("cover", false, "record which forms and if branches of the source file(s) run, and on exit print a coverage summary to stderr")
+	cover_html := flag.String("cover-html", "", "record coverage as with -cover, and on exit write the source file(s) with the forms not run marked to this HTML file")
+	cover_json := flag.String("cover-json", "", "record coverage as with -cover, and on exit write it to this JSON file")
+	cover_min := flag.Float64("cover-min", 0, "record coverage as with -cover, and exit with code 1 if less than this percentage of forms ran")
+	verbose := flag.Bool("v", false, "with maltest: also list each failed input with its expected and actual output")
+	flag.Parse()
+	exit_code := 0
+	defer func() { // deferred first, so it runs after all other defers
+		if exit_code != 0 {
+			os.Exit(exit_code)
+		}
+	}()
+
+	if (*cover_html != "") && (*cover_html == *cover_json) {
+		os.Stderr.WriteString("-cover-html and -cover-json must name different files\n")
+		exit_code = 2
+		return
+	}
+
+	options := lisp.Options{MalCompat: (os.Getenv("MAL_COMPAT") != ""), NoTco: (os.Getenv("NO_TCO") != ""), Bytecode: (os.Getenv("BYTECODE") != "")}
+	if flag.Arg(0) == "test" {
+		num_failed, err := lisp.RunTestFiles(options, os.Stdout, flag.Args()[1:]...)
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+		}
+		if (err != nil) || (num_failed > 0) {
+			exit_code = 1
+		}
+		return
+	} else if flag.Arg(0) == "maltest" {
+		err := os.Chdir(cmp.Or(flag.Arg(1), "mal/tests")) // as their inputs refer to files relative to there
+		if err == nil {
+			_, err = lisp.RunMalTests(options, os.Stdout, *verbose, ".")
+		}
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			exit_code = 1
 		}
+		return
+	}
+	if flag.NArg() > 1 {
+		options.OsArgs = flag.Args()[1:]
+	}
+	interp := lisp.NewInterpreter(options)
+
+	interp.Tracer.JSON = *trace_json
+	if *trace_names != "" {
+		interp.Tracer.Set(true, strings.Split(*trace_names, ",")...)
 	}
-	if _, err := readAndEval("(" + string(exprIdentDo) + " " + srcMiniStdlibNonMacros + "\n" + string(exprNil) + ")"); err != nil {
-		panic(err)
+	if *trace_out != "" {
+		file, err := os.Create(*trace_out)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		interp.Tracer.Out = file
 	}
 
-	addOsArgsToEnv()
+	if *prof || (*prof_out != "") {
+		interp.Profiler.Start()
+		defer writeProfile(interp, *prof, *prof_out)
+	}
 
-	if malCompat {
-		ensureMALCompatibility()
+	if *cover || (*cover_html != "") || (*cover_json != "") || (*cover_min > 0) {
+		interp.Coverage.Start()
+		defer func() {
+			if !writeCoverage(interp, *cover, *cover_html, *cover_json, *cover_min) {
+				exit_code = 1
+			}
+		}()
 	}
 
 	// check if we are to run the REPL or run a specified source file
-	if len(os.Args) > 1 { // run the specified source file and exit
-		if _, err := readAndEval(fmt.Sprintf("(loadFile %q)", os.Args[1])); err != nil {
-			panic(err)
+	if flag.NArg() > 0 { // run the specified source file and exit
+		if *debug || (*breaks != "") {
+			for _, spec := range strings.Split(*breaks, ",") {
+				if spec = strings.TrimSpace(spec); spec == "" {
+					continue
+				} else if _, err := strconv.Atoi(spec); err == nil {
+					spec = flag.Arg(0) + ":" + spec
+				}
+				interp.Debugger.Break(true, spec)
+			}
+			interp.Debugger.Start(*breaks == "")
+		}
+		var exit_err *lisp.ExitErr
+		if err := interp.LoadFile(flag.Arg(0)); errors.As(err, &exit_err) {
+			exit_code = exit_err.Code
+		} else if err != nil {
+			os.Stderr.WriteString(lisp.ErrStackTrace(err) + "\n")
+			exit_code = 1
 		}
 		return
 	}
+	exit_code = interp.Repl()
+}
 
-	// read-eval-print loop (REPL)
-	readln := bufio.NewScanner(os.Stdin) // want line-editing? just run with `rlwrap`
-	const prompt = "\n࿊  "
-	for fmt.Print(prompt); readln.Scan(); fmt.Print(prompt) {
-		input := strings.TrimSpace(readln.Text())
-		expr, err := readAndEval(input)
+// writeProfile stops the profiler of `interp`, then prints its table to stderr if `table` and writes its pprof profile to `outFilePath` if given.
+func writeProfile(interp *lisp.Interpreter, table bool, outFilePath string) {
+	interp.Profiler.Stop()
+	if table {
+		_ = interp.Profiler.WriteTable(os.Stderr)
+	}
+	if outFilePath != "" {
+		file, err := os.Create(outFilePath)
 		if err != nil {
-			msg := err.Error()
-			os.Stderr.WriteString(strings.Repeat("~", 2+len(msg)) + "\n " + msg + "\n" + strings.Repeat("~", 2+len(msg)) + "\n")
-		} else if output := str(true, expr); output != "" {
-			fmt.Println(output)
+			panic(err)
+		}
+		defer file.Close()
+		if err = interp.Profiler.WritePprof(file); err != nil {
+			panic(err)
 		}
-	}
-	if err := readln.Err(); err != nil {
-		panic(err)
 	}
 }
 
-func readAndEval(str string) (Expr, error) {
-	expr, err := readExpr(str)
-	if err != nil || expr == nil {
-		return nil, err
+// writeCoverage stops the coverage recording of `interp`, then prints its summary to stderr if `summary` and writes its reports to
+// `htmlFilePath` and `jsonFilePath` if given. it returns `false` if less than `minPercent` of all forms ran.
+func writeCoverage(interp *lisp.Interpreter, summary bool, htmlFilePath string, jsonFilePath string, minPercent float64) bool {
+	interp.Coverage.Stop()
+	if summary {
+		_ = interp.Coverage.WriteText(os.Stderr)
+	}
+	for _, report := range []struct {
+		filePath string
+		write    func(io.Writer) error
+	}{{htmlFilePath, interp.Coverage.WriteHTML}, {jsonFilePath, interp.Coverage.WriteJSON}} {
+		if report.filePath == "" {
+			continue
+		}
+		file, err := os.Create(report.filePath)
+		if err != nil {
+			panic(err)
+		}
+		err = report.write(file)
+		_ = file.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := interp.Coverage.CheckMin(minPercent); err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return false
 	}
-	return evalAndApply(&envMain, expr)
+	return true
 }
-
-const srcMiniStdlibNonMacros = `
-
-
-(def not
-	(fn (any)
-		(if any :false :true)))
-
-(def nth at)
-
-(def first
-	(fn (list)
-		(at list 0)))
-
-(def rest
-	(fn (list)
-		(at list 1 -1)))
-
-(def loadFile
-	(fn (srcFilePath)
-		(def src (readTextFile srcFilePath))
-		(set src (str "(do " src "\n:nil)"))
-		(def expr (readExpr src))
-		(eval expr)))
-
-(def map
-	(fn (func list)
-		(if (isEmpty list)
-			()
-			(cons (func (first list)) (map func (rest list))))))
-
-`
-
-const srcMiniStdlibMacros = `
-
-
-(def caseOf
-	(macro (cases)
-		(if (isEmpty cases)
-			:nil
-			(let (	(case (at cases 0))
-					(case_cond (at case 0))
-					(case_then (at case 1)))
-				´(if ~case_cond
-						~case_then
-						(caseOf ~(rest cases)))))))
-
-(def and
-	(macro (any1 any2)
-		´(if ~any1 ~any2 :false)))
-
-(def or
-	(macro (any1 any2)
-		´(if ~any1 ~any1 ~any2)))
-
-(def postfix ;;; turns (1 2 +) into (+ 1 2)
-	(macro (call)
-		(if (and (is :list call) (> (count call) 1))
-			(cons (at call -1) (at call 0 -2))
-			call)))
-
-
-`